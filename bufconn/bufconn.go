@@ -10,6 +10,8 @@ import (
 	"net"
 	"net/url"
 	"time"
+
+	"github.com/lifenjoiner/pd/network"
 )
 
 // Conn is a connection with bufio reader.
@@ -50,9 +52,9 @@ func NewConn(c net.Conn) *Conn {
 	return cc
 }
 
-// Dial dials the address with timeout.
-func Dial(network, address string, timeout time.Duration) (*Conn, error) {
-	c, err := net.DialTimeout(network, address, timeout)
+// Dial dials the address with timeout by the dialer, a nil one is the system dialer.
+func Dial(nd network.Dialer, proto, address string, timeout time.Duration) (*Conn, error) {
+	c, err := network.DialTimeout(nd, proto, address, timeout)
 	var conn *Conn
 	if err == nil {
 		_ = c.SetDeadline(time.Now().Add(timeout))
@@ -61,8 +63,8 @@ func Dial(network, address string, timeout time.Duration) (*Conn, error) {
 	return conn, err
 }
 
-// DialURL dials the URL with timeout.
-func DialURL(u *url.URL, d time.Duration) (*Conn, error) {
+// DialURL dials the URL with timeout by the dialer, a nil one is the system dialer.
+func DialURL(nd network.Dialer, u *url.URL, d time.Duration) (*Conn, error) {
 	a := u.Host
 	if len(u.Port()) == 0 {
 		a += ":" + u.Scheme
@@ -71,7 +73,7 @@ func DialURL(u *url.URL, d time.Duration) (*Conn, error) {
 	if u.Scheme == "h3" {
 		n = "udp"
 	}
-	return Dial(n, a, d)
+	return Dial(nd, n, a, d)
 }
 
 // ReadData is non-blocking.
@@ -10,6 +10,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/lifenjoiner/pd/network"
 )
 
 // HTTPConn represents a HTTP connection.
@@ -69,8 +71,8 @@ func (c *HTTPConn) GetConn() *Conn {
 	return (*Conn)(c)
 }
 
-// DialHTTP dials a HTTP URL with timeout.
-func DialHTTP(u *url.URL, d time.Duration) (*HTTPConn, error) {
-	c, err := DialURL(u, d)
+// DialHTTP dials a HTTP URL with timeout by the dialer, a nil one is the system dialer.
+func DialHTTP(nd network.Dialer, u *url.URL, d time.Duration) (*HTTPConn, error) {
+	c, err := DialURL(nd, u, d)
 	return (*HTTPConn)(c), err
 }
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lifenjoiner/pd/network"
 	"github.com/lifenjoiner/pd/protocol/socks"
 )
 
@@ -67,8 +68,8 @@ func (c *Socks4aConn) GetConn() *Conn {
 	return (*Conn)(c)
 }
 
-// DialSocks4a dials a socks4a URL with timeout.
-func DialSocks4a(u *url.URL, d time.Duration) (*Socks4aConn, error) {
-	c, err := DialURL(u, d)
+// DialSocks4a dials a socks4a URL with timeout by the dialer, a nil one is the system dialer.
+func DialSocks4a(nd network.Dialer, u *url.URL, d time.Duration) (*Socks4aConn, error) {
+	c, err := DialURL(nd, u, d)
 	return (*Socks4aConn)(c), err
 }
@@ -10,6 +10,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lifenjoiner/pd/network"
 	"github.com/lifenjoiner/pd/protocol/socks"
 )
 
@@ -81,8 +82,8 @@ func (c *Socks5Conn) GetConn() *Conn {
 	return (*Conn)(c)
 }
 
-// DialSocks5 dials a socks5 URL with timeout.
-func DialSocks5(u *url.URL, d time.Duration) (*Socks5Conn, error) {
-	c, err := DialURL(u, d)
+// DialSocks5 dials a socks5 URL with timeout by the dialer, a nil one is the system dialer.
+func DialSocks5(nd network.Dialer, u *url.URL, d time.Duration) (*Socks5Conn, error) {
+	c, err := DialURL(nd, u, d)
 	return (*Socks5Conn)(c), err
 }
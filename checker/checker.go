@@ -11,15 +11,18 @@ import (
 	"time"
 
 	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/network"
 	"github.com/lifenjoiner/pd/protocol"
 )
 
 // TargetChecker is the struct to check an URL directly or through proxy.
+// A nil Dialer is the system dialer.
 type TargetChecker struct {
 	*url.URL
 	Timeout time.Duration
 	Conn    *bufconn.Conn
 	Proxied *url.URL
+	Dialer  network.Dialer
 }
 
 // Transfer operates the check communication.
@@ -55,11 +58,11 @@ func (ck *TargetChecker) Check() (err error) {
 	var cs bufconn.ConnSolver
 	switch ck.URL.Scheme {
 	case "http", "https":
-		cs, err = bufconn.DialHTTP(ck.URL, ck.Timeout)
+		cs, err = bufconn.DialHTTP(ck.Dialer, ck.URL, ck.Timeout)
 	case "socks5":
-		cs, err = bufconn.DialSocks5(ck.URL, ck.Timeout)
+		cs, err = bufconn.DialSocks5(ck.Dialer, ck.URL, ck.Timeout)
 	case "socks4a":
-		cs, err = bufconn.DialSocks4a(ck.URL, ck.Timeout)
+		cs, err = bufconn.DialSocks4a(ck.Dialer, ck.URL, ck.Timeout)
 	default:
 		err = errors.New("TargetChecker: unknown proxy scheme: " + ck.URL.Scheme)
 		return
@@ -87,7 +90,7 @@ func (ck *TargetChecker) Check() (err error) {
 
 // NewTargetChecker packs a new TargetChecker.
 func NewTargetChecker(u *url.URL, d time.Duration, c *bufconn.Conn, p *url.URL) *TargetChecker {
-	return &TargetChecker{u, d, c, p}
+	return &TargetChecker{URL: u, Timeout: d, Conn: c, Proxied: p}
 }
 
 // New generates a new TargetChecker from a URL string.
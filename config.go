@@ -6,6 +6,8 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 	"strings"
 	"time"
 
@@ -14,32 +16,127 @@ import (
 
 // Config of the tool.
 type Config struct {
-	Listens      []string
-	NetProbeURL  string
-	SvrConf      server.Config
-	StatFile     string
-	StatValidity time.Duration
-	Blocked      string
-	Direct       string
+	Command        string
+	Listens        []string
+	Transparents   []string
+	NetProbeURL    string
+	NetProbeQuorum int
+	NetProfile     string
+	DirectBind     string
+	ProxyBind      string
+	DirectRoutes   string
+	Allow          string
+	Limiter        server.Limiter
+	Bandwidth      string
+	BreakerFails   int
+	BreakerWindow  time.Duration
+	BreakerCool    time.Duration
+	Deny           string
+	SvrConf        server.Config
+	StatFile       string
+	ProxyStatFile  string
+	StatValidity   time.Duration
+	Blocked        string
+	Direct         string
+	TLSRules       string
+	Signatures     string
+	TLSRoots       string
+	TLSPins        string
+	LearnedBlocked string
+	LearnedDirect  string
+	LearnAuto      bool
+	LearnValidity  time.Duration
+	LearnMinCount  int
+	Strategy       string
+	Thresholds     string
+	Backoffs       string
+	DirectTries    int
+	ProxyTries     int
+	Affinity       time.Duration
+	UpstreamOrder  string
+	AffinityScope  string
+	DNS            string
+	DNSDirect      string
+	DNSBlocked     string
+	DNSCacheTTL    time.Duration
+	DNSNegativeTTL time.Duration
 }
 
 func parseConfig() *Config {
 	conf := &Config{}
 
 	s := flag.String("listens", "127.0.0.1:6699", "Listen addresses: [Host]:Port[,[Host]:Port][...]")
+	t := flag.String("transparent", "", "Listen addresses for the connections redirected by iptables REDIRECT/TPROXY (linux), the same format as -listens. Empty disables it.")
 	flag.DurationVar(&conf.SvrConf.UpstreamTimeout, "upstreamtimeout", 5*time.Second, "LookupHost/Dial/HandShake timeout, 3-7s is recommended. 20 * me for data transfer.")
-	flag.StringVar(&conf.NetProbeURL, "netprobeurl", "https://example.com", "Used to probe if we are offline, and to ignore offline failures.")
+	flag.StringVar(&conf.NetProbeURL, "netprobeurl", "https://example.com", "Comma separated URLs used to probe if we are offline, and to ignore offline failures.")
+	flag.IntVar(&conf.NetProbeQuorum, "netprobequorum", 1, "Minimum responding URLs of -netprobeurl to be online.")
+	flag.StringVar(&conf.NetProfile, "netprofile", "", "Keep separate stats per network, fingerprinted by: gateway, local (address), or a probe URL. Empty disables it.")
+	flag.StringVar(&conf.DirectBind, "directbind", "", "Bind the direct connections to source IPs (one per family) and/or an interface (linux): [IPv4][,IPv6][,Interface]. Empty doesn't bind.")
+	flag.StringVar(&conf.ProxyBind, "proxybind", "", "Bind the connections to upstream proxies, the same format as -directbind.")
+	flag.StringVar(&conf.DirectRoutes, "directroutes", "", "Direct routes competing by the stats per host: Name=Bind[;Name=Bind][...], Bind is the format of -directbind, an empty Bind is -directbind. Empty is the single route by -directbind.")
 	flag.BoolVar(&conf.SvrConf.ParallelDial, "paralleldial", true, "Try parallelly dial up IPs of a host.")
+	flag.DurationVar(&conf.SvrConf.DialDelay, "dialdelay", 250*time.Millisecond, "Happy Eyeballs delay between the parallel dials to the IPs of a host, 0 dials them one by one.")
+	flag.DurationVar(&conf.SvrConf.RaceDelay, "racedelay", 0, "Race a direct and a proxied connection started after the delay for unknown or ambiguous hosts (CONNECT only), 0 disables it.")
+	flag.StringVar(&conf.SvrConf.IPFamily, "ipfamily", "prefer-ipv6", "IP family preference for direct connections: prefer-ipv6, prefer-ipv4, ipv4-only, ipv6-only.")
 	flag.StringVar(&conf.SvrConf.Proxies, "proxies", "", "Upstream proxy urls: [Scheme://]Host:Port[,[Scheme://]Host:Port][...], omitting scheme adopts all supported schemes (http, socks5, socks4a).")
 	flag.StringVar(&conf.SvrConf.ProxyProbeURL, "proxyprobeurl", "https://www.google.com", "Used to probe if a proxy works.")
+	flag.StringVar(&conf.Allow, "allow", "", "Client IPs or CIDRs allowed to use the service: IP|CIDR[,IP|CIDR][...]. Empty allows all.")
+	flag.StringVar(&conf.Deny, "deny", "", "Client IPs or CIDRs denied to use the service, the same format as -allow. Deny > Allow.")
+	flag.IntVar(&conf.Limiter.MaxConns, "maxconns", 0, "Max concurrent client connections, 0 is unlimited.")
+	flag.IntVar(&conf.Limiter.MaxClientConns, "maxclientconns", 0, "Max concurrent connections per client IP, 0 is unlimited.")
+	flag.Float64Var(&conf.Limiter.Rate, "connrate", 0, "Max new client connections per second, 0 is unlimited.")
+	flag.Float64Var(&conf.Limiter.ClientRate, "clientconnrate", 0, "Max new connections per second per client IP, 0 is unlimited.")
+	flag.DurationVar(&conf.Limiter.Queue, "connqueue", 0, "Max time a connection over the limits waits for a slot, 0 refuses it at once.")
+	flag.StringVar(&conf.Bandwidth, "bandwidth", "", "Bandwidth shaping rules of Scope=Up/Down bytes per second (k/M/G suffix, 0 unlimited): Scope is all, client (each), direct, proxy (each), proxy:Host:Port, domain:Suffix. E.g. all=0/8M,client=1M/2M,domain:example.com=0/512k")
 	flag.StringVar(&conf.SvrConf.PacFile, "pac", "", "PAC file provided as a server.")
 	flag.DurationVar(&conf.StatValidity, "statvalidity", 168*time.Hour, "Validity of a stat.")
 	flag.StringVar(&conf.StatFile, "statfile", "stat.json", "File records direct connection quality (EWMA of the last 10).")
+	flag.StringVar(&conf.ProxyStatFile, "proxystatfile", "proxy-stat.json", "File records proxy connection quality per destination domain, to rank the proxies by. Empty disables it.")
 	flag.StringVar(&conf.Blocked, "blocked", "blocked", "File of blocked domains (suffix) or IPs (prefix), that go proxied directly. Do 1 direct try, if no proxy.")
 	flag.StringVar(&conf.Direct, "direct", "direct", "File of direct domains (suffix) or IPs (prefix), that won't go proxied. Direct > Blocked.")
+	flag.StringVar(&conf.TLSRules, "tlsrules", "", "File of rules by the TLS ClientHello of CONNECT, overriding -blocked/-direct: [sni=Suffix] [alpn=Proto] [nosni] direct|blocked per line, the first match wins. Empty disables it.")
+	flag.StringVar(&conf.Signatures, "blocksignatures", "", "File of block page signatures of direct plain HTTP responses: text Substring or location HostSuffix (redirect) per line. Redirects to IPs and resets before responding are always taken as blocked.")
+	flag.StringVar(&conf.TLSRoots, "tlsroots", "", "Detect TLS 1.2 interception of direct connections by the trust store: system or a PEM file. Empty disables it.")
+	flag.StringVar(&conf.TLSPins, "tlspins", "", "File of pinned keys to detect TLS 1.2 interception of direct connections: Host(suffix) SHA256-of-SPKI-in-hex per line, checked before -tlsroots.")
+	flag.StringVar(&conf.LearnedBlocked, "learnedblocked", "learned-blocked", "File of domains promoted from stats (JSON of domain: expiry), that go proxied directly. Blocked/Direct > Learned.")
+	flag.StringVar(&conf.LearnedDirect, "learneddirect", "learned-direct", "File of domains promoted from stats (JSON of domain: expiry), that won't go proxied. Blocked/Direct > Learned.")
+	flag.BoolVar(&conf.LearnAuto, "learnauto", false, "Promote persistently failing/good domains from stats into the learned files on start.")
+	flag.DurationVar(&conf.LearnValidity, "learnvalidity", 72*time.Hour, "Validity of a learned domain.")
+	flag.IntVar(&conf.LearnMinCount, "learnmincount", 30, "Minimum visits of a domain to be learned.")
+	flag.StringVar(&conf.Strategy, "strategy", "default", "Dispatch strategy: default, threshold (configured by -thresholds, -backoffs, -directtries and -proxytries).")
+	flag.StringVar(&conf.Thresholds, "thresholds", "0.8:3,0.6:2,0.4:1", "Threshold table of Value:Tries in descending order, a host above Value gets Tries direct tries.")
+	flag.StringVar(&conf.Backoffs, "backoffs", "0.3:5m,0.2:7m,0.1:13m,0:31m", "Back-off table of Value:Wait, a poor host above Value gets a direct try after Wait, Value 0 matches any.")
+	flag.IntVar(&conf.DirectTries, "directtries", 3, "Max direct tries of the static direct hosts for the threshold strategy.")
+	flag.IntVar(&conf.ProxyTries, "proxytries", 3, "Max proxy tries for the threshold strategy.")
+	flag.StringVar(&conf.UpstreamOrder, "upstreamorder", "same,socks5,http,socks4a", "Upstream proxy types a request goes by in order, translated between protocols: same (as the client), http, socks5, socks4a. Types can carry the destination only.")
+	flag.DurationVar(&conf.Affinity, "proxyaffinity", 0, "Stick a host to the proxy it last succeeded by for the duration, falling back to the ranking when it fails. 0 disables it.")
+	flag.StringVar(&conf.AffinityScope, "proxyaffinityscope", "domain", "The scope sticking to a proxy: host or domain (registrable).")
+	flag.IntVar(&conf.BreakerFails, "breakerfails", 0, "Fail a host:port fast after it fails by all routes these times in -breakerwindow, 0 disables it.")
+	flag.DurationVar(&conf.BreakerWindow, "breakerwindow", time.Minute, "The window to count the failures of a host:port.")
+	flag.DurationVar(&conf.BreakerCool, "breakercooldown", time.Minute, "The time a host:port fails fast for, then a single trial goes.")
+	flag.StringVar(&conf.DNS, "dns", "", "DNS servers tried in order: [udp://]Host[:Port], tcp://Host[:Port], tls://Host[:Port], https://Host[:Port]/Path, tcp/tls/https can be reached through a proxy by '?proxy=Scheme://Host:Port'. Empty uses the system resolver.")
+	flag.StringVar(&conf.DNSDirect, "dnsdirect", "", "DNS servers for the static direct hosts, the same format as -dns. Empty uses -dns.")
+	flag.StringVar(&conf.DNSBlocked, "dnsblocked", "", "DNS servers for the static blocked hosts, the same format as -dns. Empty uses -dns.")
+	flag.DurationVar(&conf.DNSCacheTTL, "dnscachettl", time.Minute, "DNS cache TTL for the results without TTL (system resolver), 0 disables the cache.")
+	flag.DurationVar(&conf.DNSNegativeTTL, "dnsnegativettl", 10*time.Second, "DNS cache TTL for the not found results.")
 
-	flag.Parse()
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage: %v [suggest] [flags]\n", name)
+		fmt.Fprintf(w, "  suggest: print the domains learned from stats, and exit.\n")
+		flag.PrintDefaults()
+	}
+
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "suggest" {
+		conf.Command = args[0]
+		args = args[1:]
+	}
+	_ = flag.CommandLine.Parse(args)
 	conf.Listens = strings.Split(*s, ",")
+	if len(*t) > 0 {
+		conf.Transparents = strings.Split(*t, ",")
+	}
 
 	return conf
 }
@@ -0,0 +1,223 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package connectivity monitors if we are online by probing multiple targets.
+package connectivity
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lifenjoiner/pd/checker"
+)
+
+// The default probing parameters.
+const (
+	// DefaultInterval is the probing interval while online.
+	DefaultInterval = time.Minute
+	// DefaultRetryInterval is the faster probing interval after a failure.
+	DefaultRetryInterval = 10 * time.Second
+	// DefaultHoldOff is the consecutive successful probes to be back online.
+	DefaultHoldOff = 2
+	// minTriggerInterval limits the triggered probes.
+	minTriggerInterval = 10 * time.Second
+)
+
+// TargetStatus is the result of a target in the last probe.
+type TargetStatus struct {
+	URL   string `json:"url"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Status is a snapshot of the Monitor.
+type Status struct {
+	Online  bool           `json:"online"`
+	Since   time.Time      `json:"since"`
+	Checked time.Time      `json:"checked"`
+	Targets []TargetStatus `json:"targets"`
+}
+
+// Monitor probes the targets periodically, we are online if at least Quorum of them respond.
+type Monitor struct {
+	online        int32 // accessed atomically
+	Checkers      []*checker.TargetChecker
+	Quorum        int
+	Interval      time.Duration
+	RetryInterval time.Duration
+	HoldOff       int
+	//local
+	mu          sync.Mutex
+	subscribers []func(online bool)
+	successes   int
+	status      Status
+	trigger     chan struct{}
+	lastTrigger time.Time
+}
+
+// New generates a Monitor from comma separated target URLs. It acts as online until proven not.
+func New(urls string, timeout time.Duration, quorum int) (*Monitor, error) {
+	m := &Monitor{
+		online:        1,
+		Interval:      DefaultInterval,
+		RetryInterval: DefaultRetryInterval,
+		HoldOff:       DefaultHoldOff,
+		trigger:       make(chan struct{}, 1),
+	}
+	for _, s := range strings.Split(urls, ",") {
+		if len(s) == 0 {
+			continue
+		}
+		ck, err := checker.New(s, timeout, "")
+		if err != nil {
+			log.Printf("[connectivity] %v: %v", err, s)
+			continue
+		}
+		m.Checkers = append(m.Checkers, ck)
+	}
+	if len(m.Checkers) == 0 {
+		return nil, errors.New("no probing URL available")
+	}
+	if quorum < 1 {
+		quorum = 1
+	}
+	if quorum > len(m.Checkers) {
+		quorum = len(m.Checkers)
+	}
+	m.Quorum = quorum
+	m.status = Status{Online: true, Since: time.Now()}
+	return m, nil
+}
+
+// Online tells if we are online. A nil Monitor is always online.
+func (m *Monitor) Online() bool {
+	return m == nil || atomic.LoadInt32(&m.online) == 1
+}
+
+// Subscribe registers a function called on each change of the state.
+func (m *Monitor) Subscribe(f func(online bool)) {
+	m.mu.Lock()
+	m.subscribers = append(m.subscribers, f)
+	m.mu.Unlock()
+}
+
+// Trigger requests a probe soon, such as on a suspicious failure. It is rate limited.
+func (m *Monitor) Trigger() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if time.Since(m.lastTrigger) < minTriggerInterval {
+		m.mu.Unlock()
+		return
+	}
+	m.lastTrigger = time.Now()
+	m.mu.Unlock()
+	select {
+	case m.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Status gets a snapshot of the Monitor. A nil Monitor is always online.
+func (m *Monitor) Status() Status {
+	if m == nil {
+		return Status{Online: true}
+	}
+	m.mu.Lock()
+	st := m.status
+	st.Targets = append([]TargetStatus(nil), m.status.Targets...)
+	m.mu.Unlock()
+	return st
+}
+
+// Probe checks the targets parallelly, and updates the state. It isn't for concurrent use.
+func (m *Monitor) Probe() bool {
+	targets := make([]TargetStatus, len(m.Checkers))
+	var wg sync.WaitGroup
+	wg.Add(len(m.Checkers))
+	for i, ck := range m.Checkers {
+		i, ck := i, ck
+		go func() {
+			defer wg.Done()
+			ts := TargetStatus{URL: ck.URL.String(), OK: true}
+			if err := ck.Check(); err != nil {
+				ts.OK = false
+				ts.Error = err.Error()
+			}
+			targets[i] = ts
+		}()
+	}
+	wg.Wait()
+
+	n := 0
+	for _, ts := range targets {
+		if ts.OK {
+			n++
+		}
+	}
+	ok := n >= m.Quorum
+	if !ok {
+		log.Printf("[connectivity] %v/%v targets responded, %v required.", n, len(targets), m.Quorum)
+	}
+	m.update(ok, targets)
+	return ok
+}
+
+// update the state by a probe result. Going offline is immediate, going online needs HoldOff successes in a row.
+func (m *Monitor) update(ok bool, targets []TargetStatus) {
+	m.mu.Lock()
+	if ok {
+		m.successes++
+	} else {
+		m.successes = 0
+	}
+	was := atomic.LoadInt32(&m.online) == 1
+	online := ok && (was || m.successes >= m.HoldOff)
+	m.status.Checked = time.Now()
+	m.status.Targets = targets
+	var subscribers []func(bool)
+	if online != was {
+		v := int32(0)
+		if online {
+			v = 1
+		}
+		atomic.StoreInt32(&m.online, v)
+		m.status.Online = online
+		m.status.Since = m.status.Checked
+		subscribers = append(subscribers, m.subscribers...)
+	}
+	m.mu.Unlock()
+
+	if online != was {
+		msg := "offline"
+		if online {
+			msg = "online"
+		}
+		log.Printf("[connectivity] We are %v.", msg)
+		for _, f := range subscribers {
+			f(online)
+		}
+	}
+}
+
+// Start probes now and periodically, faster while offline.
+func (m *Monitor) Start() {
+	go func() {
+		for {
+			d := m.Interval
+			if !m.Probe() || !m.Online() {
+				d = m.RetryInterval
+			}
+			select {
+			case <-time.After(d):
+			case <-m.trigger:
+			}
+		}
+	}()
+}
@@ -0,0 +1,121 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package connectivity
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/network"
+)
+
+// newMonitor generates a Monitor of the targets on a fake network.
+func newMonitor(t *testing.T, fake *network.Fake, quorum int, targets ...string) *Monitor {
+	urls := ""
+	for _, target := range targets {
+		urls += "http://" + target + ":80,"
+	}
+	m, err := New(urls, 200*time.Millisecond, quorum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ck := range m.Checkers {
+		ck.Dialer = fake
+	}
+	return m
+}
+
+// up makes a target respond, or refuse.
+func up(fake *network.Fake, target string, ok bool) {
+	if !ok {
+		fake.Handle(target+":80", network.Refuse, nil)
+		return
+	}
+	fake.Handle(target+":80", network.Accept, func(c net.Conn) {
+		_, _ = c.Read(make([]byte, 1024))
+		_, _ = c.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	})
+}
+
+func TestQuorum(t *testing.T) {
+	fake := network.NewFake()
+	targets := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	m := newMonitor(t, fake, 2, targets...)
+	var mu sync.Mutex
+	var changes []bool
+	m.Subscribe(func(online bool) {
+		mu.Lock()
+		changes = append(changes, online)
+		mu.Unlock()
+	})
+
+	up(fake, targets[0], true)
+	up(fake, targets[1], true)
+	up(fake, targets[2], false)
+	if !m.Probe() || !m.Online() {
+		t.Fatal("2 of 3 is the quorum")
+	}
+	st := m.Status()
+	if !st.Online || len(st.Targets) != 3 || !st.Targets[0].OK || st.Targets[2].OK || st.Targets[2].Error == "" {
+		t.Fatalf("status: %+v", st)
+	}
+
+	// Going offline is immediate.
+	up(fake, targets[1], false)
+	if m.Probe() || m.Online() || m.Status().Online {
+		t.Fatal("1 of 3 is offline")
+	}
+
+	// Going online needs HoldOff successes in a row.
+	up(fake, targets[1], true)
+	if !m.Probe() || m.Online() {
+		t.Fatal("held off")
+	}
+	if !m.Probe() || !m.Online() {
+		t.Fatal("back online")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Fatalf("changes: %v", changes)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	m := newMonitor(t, network.NewFake(), 1, "192.0.2.1")
+	m.Trigger()
+	if len(m.trigger) != 1 {
+		t.Fatal("triggered")
+	}
+	<-m.trigger
+	m.Trigger()
+	if len(m.trigger) != 0 {
+		t.Fatal("rate limited")
+	}
+	m.lastTrigger = time.Now().Add(-minTriggerInterval)
+	m.Trigger()
+	m.Trigger()
+	if len(m.trigger) != 1 {
+		t.Fatal("triggered once")
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(",", time.Second, 1); err == nil {
+		t.Fatal("no target")
+	}
+	m, err := New("http://192.0.2.1,http://192.0.2.2", time.Second, 5)
+	if err != nil || m.Quorum != 2 || !m.Online() {
+		t.Fatalf("quorum: %v, %v", m, err)
+	}
+
+	var nilMonitor *Monitor
+	nilMonitor.Trigger()
+	if !nilMonitor.Online() || !nilMonitor.Status().Online {
+		t.Fatal("a nil Monitor is online")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+// The sticky hosts to keep before cleaning the expired ones up.
+const maxAffinities = 4096
+
+// sticky is the proxy a host sticks to until the expiry.
+type sticky struct {
+	proxy  string
+	expiry time.Time
+}
+
+// Affinity remembers the proxy a host (or registrable domain) last succeeded by for TTL,
+// so the connections of a web session exit through the same proxy. A nil Affinity doesn't stick.
+type Affinity struct {
+	TTL    time.Duration
+	Domain bool
+	//local
+	mu      sync.Mutex
+	proxies map[string]*sticky
+}
+
+// NewAffinity generates a new Affinity, scope is "host" or "domain".
+func NewAffinity(ttl time.Duration, scope string) (*Affinity, error) {
+	a := &Affinity{TTL: ttl, proxies: make(map[string]*sticky)}
+	switch scope {
+	case "host":
+	case "domain":
+		a.Domain = true
+	default:
+		return nil, fmt.Errorf("unknown affinity scope: %v", scope)
+	}
+	return a, nil
+}
+
+// key of a host for a server type, as the ProxyPools are by the server types.
+func (a *Affinity) key(serverType, host string) string {
+	if a.Domain {
+		host = statichost.RegistrableDomain(host)
+	}
+	return serverType + " " + host
+}
+
+// Get the unexpired proxy URL of the host, or "".
+func (a *Affinity) Get(serverType, host string) string {
+	if a == nil {
+		return ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.proxies[a.key(serverType, host)]
+	if s == nil || time.Now().After(s.expiry) {
+		return ""
+	}
+	return s.proxy
+}
+
+// Remember the proxy URL the host succeeded by.
+func (a *Affinity) Remember(serverType, host, proxy string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if len(a.proxies) >= maxAffinities {
+		for k, s := range a.proxies {
+			if now.After(s.expiry) {
+				delete(a.proxies, k)
+			}
+		}
+	}
+	a.proxies[a.key(serverType, host)] = &sticky{proxy: proxy, expiry: now.Add(a.TTL)}
+}
+
+// Forget the proxy URL of the host if it failed.
+func (a *Affinity) Forget(serverType, host, proxy string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	k := a.key(serverType, host)
+	if s := a.proxies[k]; s != nil && s.proxy == proxy {
+		delete(a.proxies, k)
+	}
+}
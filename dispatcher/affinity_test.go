@@ -0,0 +1,67 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+func TestAffinity(t *testing.T) {
+	if _, err := NewAffinity(time.Minute, "url"); err == nil {
+		t.Fatal("bad scope")
+	}
+	a, _ := NewAffinity(50*time.Millisecond, "domain")
+	a.Remember("http", "www.example.com", "http://p1")
+	if a.Get("http", "static.example.com") != "http://p1" || a.Get("socks5", "www.example.com") != "" {
+		t.Fatal("should stick by the registrable domain and the server type")
+	}
+	a.Forget("http", "example.com", "http://p2")
+	if a.Get("http", "example.com") != "http://p1" {
+		t.Fatal("should forget the failed proxy only")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if a.Get("http", "example.com") != "" {
+		t.Fatal("should expire")
+	}
+
+	var nilAffinity *Affinity
+	nilAffinity.Remember("http", "example.com", "http://p1")
+	if nilAffinity.Get("http", "example.com") != "" {
+		t.Fatal("nil Affinity doesn't stick")
+	}
+}
+
+func TestDispatchProxyAffinity(t *testing.T) {
+	e, fake := newFakeEngine("http://192.0.2.8:8080", "http://192.0.2.9:8080")
+	e.StaticHosts["example.com"] = statichost.StaticBlocked
+	e.Affinity, _ = NewAffinity(time.Minute, "host")
+	resp := serveHTTP("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nproxied")
+	fake.Handle("192.0.2.8:8080", network.Accept, resp)
+	fake.Handle("192.0.2.9:8080", network.Accept, resp)
+
+	// The fastest one goes first, then the host sticks to it.
+	if ok, _ := dispatchGet(t, e, "example.com"); !ok || fake.Dials("192.0.2.8:8080") != 1 {
+		t.Fatal("should go by the fastest proxy")
+	}
+	e.Affinity.Remember("http", "example.com", "http://192.0.2.9:8080")
+	if ok, _ := dispatchGet(t, e, "example.com"); !ok || fake.Dials("192.0.2.9:8080") != 1 {
+		t.Fatal("should go by the sticky proxy")
+	}
+
+	// The sticky one fails, fall back to the ranking.
+	fake.Handle("192.0.2.9:8080", network.Refuse, nil)
+	ok, body := dispatchGet(t, e, "example.com")
+	if !ok || !strings.HasSuffix(body, "proxied") || fake.Dials("192.0.2.9:8080") != 2 || fake.Dials("192.0.2.8:8080") != 2 {
+		t.Fatalf("should fall back: %v, %q", ok, body)
+	}
+	if e.Affinity.Get("http", "example.com") != "http://192.0.2.8:8080" {
+		t.Fatal("should stick to the new one")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"sync"
+	"time"
+)
+
+// The circuits to keep before cleaning the stale ones up.
+const maxCircuits = 1024
+
+// circuit is the state of a host:port.
+// Closed: openUntil is zero. Open: before openUntil. Half-open: after openUntil, a single trial goes.
+type circuit struct {
+	fails     []time.Time
+	openUntil time.Time
+	trial     time.Time
+}
+
+// Breaker fails the host:port fast for Cooldown, after it fails by all routes Fails times in Window.
+// Then it half-opens with a single trial, the circuit closes if it succeeds, or opens again.
+// A nil Breaker never opens.
+type Breaker struct {
+	Fails    int
+	Window   time.Duration
+	Cooldown time.Duration
+	//local
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewBreaker generates a new Breaker.
+func NewBreaker(fails int, window, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		Fails:    fails,
+		Window:   window,
+		Cooldown: cooldown,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// Allow tells if the host:port can be tried.
+func (b *Breaker) Allow(h string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := b.circuits[h]
+	if c == nil || c.openUntil.IsZero() {
+		return true
+	}
+	now := time.Now()
+	if now.Before(c.openUntil) {
+		return false
+	}
+	// A trial without result, such as the client quits, expires in Cooldown.
+	if !c.trial.IsZero() && now.Sub(c.trial) < b.Cooldown {
+		return false
+	}
+	c.trial = now
+	return true
+}
+
+// Success closes the circuit of the host:port.
+func (b *Breaker) Success(h string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	delete(b.circuits, h)
+	b.mu.Unlock()
+}
+
+// Failure records a failure of the host:port by all routes, and tells if the circuit opens.
+func (b *Breaker) Failure(h string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	c := b.circuits[h]
+	if c == nil {
+		if len(b.circuits) >= maxCircuits {
+			b.cleanup(now)
+		}
+		c = &circuit{}
+		b.circuits[h] = c
+	}
+	if !c.openUntil.IsZero() {
+		// The trial failed.
+		c.openUntil = now.Add(b.Cooldown)
+		c.trial = time.Time{}
+		return true
+	}
+	fails := c.fails[:0]
+	for _, t := range c.fails {
+		if now.Sub(t) < b.Window {
+			fails = append(fails, t)
+		}
+	}
+	c.fails = append(fails, now)
+	if len(c.fails) >= b.Fails {
+		c.fails = nil
+		c.openUntil = now.Add(b.Cooldown)
+		return true
+	}
+	return false
+}
+
+// cleanup the circuits not failing recently.
+func (b *Breaker) cleanup(now time.Time) {
+	for h, c := range b.circuits {
+		if c.openUntil.IsZero() {
+			if len(c.fails) == 0 || now.Sub(c.fails[len(c.fails)-1]) >= b.Window {
+				delete(b.circuits, h)
+			}
+		} else if now.Sub(c.openUntil) >= b.Cooldown && (c.trial.IsZero() || now.Sub(c.trial) >= b.Cooldown) {
+			delete(b.circuits, h)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	h := "example.com:443"
+	b := NewBreaker(3, time.Minute, 50*time.Millisecond)
+	for i := 1; i < 3; i++ {
+		if b.Failure(h) || !b.Allow(h) {
+			t.Fatalf("opens after %v failures", i)
+		}
+	}
+	if !b.Failure(h) || b.Allow(h) {
+		t.Fatal("should open")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow(h) || b.Allow(h) {
+		t.Fatal("should half-open with a single trial")
+	}
+	if !b.Failure(h) || b.Allow(h) {
+		t.Fatal("should open again after a failed trial")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !b.Allow(h) {
+		t.Fatal("should half-open")
+	}
+	b.Success(h)
+	if !b.Allow(h) || !b.Allow(h) || b.Failure(h) {
+		t.Fatal("should close")
+	}
+
+	var nb *Breaker
+	if !nb.Allow(h) || nb.Failure(h) {
+		t.Fail()
+	}
+}
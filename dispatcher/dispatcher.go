@@ -6,53 +6,86 @@
 package dispatcher
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/lifenjoiner/pd/bufconn"
-	"github.com/lifenjoiner/pd/checker"
+	"github.com/lifenjoiner/pd/connectivity"
 	"github.com/lifenjoiner/pd/forwarder"
 	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/network"
 	"github.com/lifenjoiner/pd/protocol"
 	"github.com/lifenjoiner/pd/protocol/http"
 	"github.com/lifenjoiner/pd/proxypool"
+	"github.com/lifenjoiner/pd/resolver"
 	"github.com/lifenjoiner/pd/statichost"
 )
 
-// The global parameters for dispatcher.
+// The global parameters for dispatcher, as the compatibility shim for a nil Engine.
 var (
 	GlobalStaticHosts statichost.StaticHosts
 	GlobalHostStats   *hoststat.HostStats
 	GlobalProxyPool   map[string]*proxypool.ProxyPool
+	GlobalStrategy    Strategy = DefaultStrategy{}
+	GlobalResolvers   map[statichost.Strategy]*resolver.Resolver
+	// If we are offline, don't update the GlobalHostStats and the ProxyPool.
+	GlobalConnectivity *connectivity.Monitor
+	GlobalShaping      *forwarder.Shaping
+	GlobalBreaker      *Breaker
+	// The later Engine parameters, the same defaults.
+	GlobalLearnedHosts  statichost.StaticHosts
+	GlobalTLSRules      statichost.TLSRules
+	GlobalSignatures    *http.Signatures
+	GlobalCertVerifier  *protocol.CertVerifier
+	GlobalProxyStats    *hoststat.HostStats
+	GlobalAffinity      *Affinity
+	GlobalUpstreamOrder []string
+	GlobalRoutes        []*Route
+	GlobalDialer        network.Dialer
+	GlobalProxyDialer   network.Dialer
+	GlobalResolver      network.Resolver
 )
 
-// If we are offline, don't update the GlobalHostStats.
-var globalOnline bool
-
 // Dispatcher struct is what a dispatcher instance is composed with.
 type Dispatcher struct {
+	Engine       *Engine
 	ServerType   string
 	Client       *bufconn.Conn
 	DestHost     string
 	DestPort     string
+	DestIP       string // the requested IP, if DestHost is sniffed
 	Timeout      time.Duration
 	ParallelDial bool
+	DialDelay    time.Duration
+	IPFamily     string
+	RaceDelay    time.Duration
 	//local
+	hello       *protocol.ClientHello
+	rule        statichost.Strategy
+	stat        hoststat.HostStat
 	maxTry      int
 	tried       int
 	directWave  float64
 	maxProxyTry int
 	proxyTried  int
+	lastResort  bool
+	sticky      *proxypool.Proxy
+	ranked      []*proxypool.Proxy
+	routes      []*Route
 }
 
-// New generates a new Dispatcher.
-func New(s string, c *bufconn.Conn, h string, p string, d time.Duration) *Dispatcher {
+// New generates a new Dispatcher. A nil Engine falls back to the global parameters.
+func New(e *Engine, s string, c *bufconn.Conn, h string, p string, d time.Duration) *Dispatcher {
+	if e == nil {
+		e = Globals()
+	}
 	return &Dispatcher{
+		Engine:     e,
 		ServerType: s,
 		Client:     c,
 		DestHost:   h,
@@ -63,229 +96,326 @@ func New(s string, c *bufconn.Conn, h string, p string, d time.Duration) *Dispat
 
 // Dispatch is the main dispatcher, that dispatches how a client connection will be served.
 func (d *Dispatcher) Dispatch(req protocol.Requester) bool {
-	d.directWave = 1
+	logPre := "[" + d.ServerType + "] " + req.Command() + " " + req.Host() + " <- " + d.Client.RemoteAddr().String()
+	h := d.DestHost + ":" + d.DestPort
+	if !d.Engine.Breaker.Allow(h) {
+		log.Printf("%v <= circuit open, fail fast", logPre)
+		_ = req.Reject(d.Client, "pd: "+h+" failed by all routes repeatedly, retry later.\n")
+		return false
+	}
+	if !d.getClientHello(req) {
+		log.Printf("%v <= TLS: no ClientHello, drop it.", logPre)
+		return false
+	}
 
 	var strategy statichost.Strategy
 	if NotInternetHost(d.DestHost) {
 		log.Printf("[dispatcher] %v isn't Internet host, won't go proxied.", d.DestHost)
 		strategy = statichost.StaticDirect
-		d.maxTry = 3
-		d.maxProxyTry = 0
 	} else {
-		d.maxProxyTry = 3
 		strategy = d.DispatchByStaticRules()
-		switch strategy {
-		case statichost.StaticDirect:
-			d.maxTry = 3
-			d.maxProxyTry = 0
-		case statichost.StaticBlocked:
-			d.maxTry = 0
-		default:
-			d.DispatchByStats()
+		if s := d.DispatchByTLSRules(); s != statichost.StaticNil {
+			strategy = s
 		}
 	}
-
-	logPre := "[" + d.ServerType + "] " + req.Command() + " " + req.Host() + " <- " + d.Client.RemoteAddr().String()
+	d.DispatchByStrategy(strategy)
 	log.Printf("%v [type:%v]", logPre, strategy)
 
-	var restart bool // failed after the 2nd client packet has been sent following ServerHello
+	ok, restart := d.serve(req, logPre)
+	if ok {
+		d.Engine.Breaker.Success(h)
+	} else if !restart && d.Engine.Connectivity.Online() && d.Engine.Breaker.Failure(h) {
+		log.Printf("%v <= failed by all routes, circuit opens for %v", logPre, d.Engine.Breaker.Cooldown)
+	}
+	return ok
+}
+
+// serve tries the routes. Restart tells if it failed after the server responsed.
+func (d *Dispatcher) serve(req protocol.Requester, logPre string) (ok, restart bool) {
 	var err error
-	ok := false
 	v := 0.0
-	h := d.DestHost + ":" + d.DestPort
-	for d.tried = 0; d.tried < d.maxTry; d.tried++ {
+	if d.shouldRace(req) {
+		ok, done := d.ServeRaced(req)
+		if done {
+			return ok, true
+		}
+	}
+
+	for ; d.tried < d.maxTry; d.tried++ {
 		restart, err = d.ServeDirect(req)
 		if err == nil {
 			ok = true
 			v = 1.0
 		}
-		if globalOnline && strategy == statichost.StaticNil {
-			GlobalHostStats.Update(h, v)
+		if d.recordStats() {
+			d.recordDirect(v)
 			if restart {
-				GlobalHostStats.Update(h, v)
+				d.recordDirect(v)
 			}
 		}
 		if ok || restart {
-			return ok
+			return
+		}
+		if errors.Is(err, forwarder.ErrIntercepted) && d.recordStats() {
+			d.Engine.HostStats.Penalize(d.directKey())
+		}
+		if errors.Is(err, forwarder.ErrBlocked) {
+			// Another ISP may not block it.
+			if d.nextRoute() {
+				log.Printf("%v <= direct is blocked by %v, try the next route", logPre, d.route().Name)
+				continue
+			}
+			// Blocked by a censor, the rest direct tries won't help.
+			log.Printf("%v <= direct is blocked, go proxied", logPre)
+			if d.maxProxyTry == 0 && d.rule != statichost.StaticDirect {
+				d.maxProxyTry = 1
+			}
+			break
+		}
+		// Dialing or receiving ServerHello failed, another ISP may reach it.
+		if d.nextRoute() {
+			log.Printf("%v <= direct failed by %v, try the next route", logPre, d.route().Name)
 		}
-		// dialing or receiving ServerHello failed
 	}
 
-	for d.proxyTried = 0; d.proxyTried < d.maxProxyTry; d.proxyTried++ {
+	for ; d.proxyTried < d.maxProxyTry; d.proxyTried++ {
 		restart, err = d.ServeProxied(req)
 		if err == nil {
-			return true
+			return true, false
 		} else if restart {
-			return false
+			return
 		}
 	}
 
 	if d.maxTry == 0 {
 		log.Printf("%v <= no proxy succeeded, try direct once", logPre)
 		d.maxTry = 1
-		_, err = d.ServeDirect(req)
+		d.lastResort = true
+		restart, err = d.ServeDirect(req)
 		if err == nil {
 			v = 1.0
 			ok = true
 		}
-		if globalOnline && strategy == statichost.StaticNil {
-			GlobalHostStats.Update(h, v)
+		if d.recordStats() {
+			d.recordDirect(v)
 		}
 	}
-	return ok
+	return
+}
+
+// getClientHello gets the first client packet of CONNECT, and parses the TLS ClientHello for the rules and logging.
+// An Internet IP destination is replaced with the hostname sniffed from it, so the rules and stats apply to it.
+// The IP is kept for the direct dials. It fails if the client sends nothing.
+func (d *Dispatcher) getClientHello(req protocol.Requester) bool {
+	if req.Command() != "CONNECT" {
+		return true
+	}
+	client := d.Client
+	_ = client.SetDeadline(time.Now().Add(2 * d.Timeout))
+	if req.GetRequest(client, client.R) != nil {
+		return false
+	}
+	if hello, err := protocol.ParseClientHello(req.Payload()); err == nil {
+		d.hello = hello
+		log.Printf("[%v] %v %v <= TLS: %v", d.ServerType, req.Command(), req.Host(), hello)
+	}
+	if !statichost.HostIsIP(d.DestHost) || NotInternetHost(d.DestHost) {
+		return true
+	}
+	if host := protocol.SniffHost(req.Payload()); host != "" {
+		log.Printf("[%v] %v %v is sniffed as %v", d.ServerType, req.Command(), req.Host(), host)
+		d.DestIP, d.DestHost = d.DestHost, host
+	}
+	return true
+}
+
+// DispatchByTLSRules decides by the ClientHello, it overrides the static rules of the host if matched.
+func (d *Dispatcher) DispatchByTLSRules() statichost.Strategy {
+	if d.hello == nil {
+		return statichost.StaticNil
+	}
+	return d.Engine.TLSRules.GetStrategy(d.hello.ServerName, d.hello.ALPN)
 }
 
 // DispatchByStaticRules decides whether the host is aways go direct or proxied.
+// Blocked/Direct > Learned.
 func (d *Dispatcher) DispatchByStaticRules() statichost.Strategy {
-	return GlobalStaticHosts.GetStrategy(d.DestHost)
+	if s := d.Engine.StaticHosts.GetStrategy(d.DestHost); s != statichost.StaticNil {
+		return s
+	}
+	return d.Engine.LearnedHosts.GetStrategy(d.DestHost)
 }
 
-// DispatchByStats solves the direct connecting tries by HostStat.
-func (d *Dispatcher) DispatchByStats() {
-	h := d.DestHost + ":" + d.DestPort
-	stat := GlobalHostStats.GetStat(h)
-	if stat.Count == 0 {
-		stat.Value = 1
+// DispatchByStrategy solves the direct and proxied tries by the d.Engine.Strategy.
+func (d *Dispatcher) DispatchByStrategy(rule statichost.Strategy) {
+	cond := Condition{Rule: rule, Online: d.Engine.Connectivity.Online()}
+	if rule == statichost.StaticNil {
+		cond.Stat = d.Engine.HostStats.GetStat(d.DestHost + ":" + d.DestPort)
 	}
+	d.rule = rule
+	d.stat = cond.Stat
+	p := d.Engine.Strategy.Plan(cond)
+	d.maxTry = p.MaxTry
+	d.maxProxyTry = p.MaxProxyTry
+	d.directWave = p.DirectWave
+}
 
-	v := stat.Value
-	d.directWave = v
+// recordStats tells if the results go into the HostStats.
+func (d *Dispatcher) recordStats() bool {
+	return d.Engine.Connectivity.Online() && d.rule == statichost.StaticNil
+}
 
-	if v > 0.8 {
-		d.maxTry = 3
-	} else if v > 0.6 {
-		d.maxTry = 2
-	} else if v > 0.4 || stat.Count <= hoststat.EwmaSlide {
-		d.maxTry = 1
-	} else {
-		dt := time.Since(stat.Time)
-		if v > 0.3 && dt > 5*time.Minute {
-			d.maxTry = 1
-		} else if v > 0.2 && dt > 7*time.Minute {
-			d.maxTry = 1
-		} else if v > 0.1 && dt > 13*time.Minute {
-			d.maxTry = 1
-		} else if dt > 31*time.Minute {
-			d.maxTry = 1
-		} else {
-			d.maxTry = 0
-		}
+// canReplay tells if another route is left to serve the client, so a blocked direct response can be dropped.
+func (d *Dispatcher) canReplay() bool {
+	if d.tried+1 < len(d.directRoutes()) {
+		return true
 	}
+	return d.rule != statichost.StaticDirect && !d.lastResort && len(d.proxies()) > 0
 }
 
-// goodConn is the helper struct for DispatchIP.
-type goodConn struct {
-	sync.RWMutex
-	c   net.Conn
-	err error
-	n   int
+// LookupHost looks up a host by the resolver for the static rule of the destination,
+// or the default one, or the Engine Resolver, or the system resolver.
+func (d *Dispatcher) LookupHost(host string) ([]string, error) {
+	r := d.Engine.Resolvers[d.rule]
+	if r == nil {
+		r = d.Engine.Resolvers[statichost.StaticNil]
+	}
+	if r != nil {
+		return r.LookupHost(context.Background(), host)
+	}
+	if d.Engine.Resolver != nil {
+		return d.Engine.Resolver.LookupHost(context.Background(), host)
+	}
+	return net.LookupHost(host)
 }
 
-// DispatchIP gets the quickest responded IP for a direct connection.
+// DispatchIP dials the IPs of the host by Happy Eyeballs for a direct connection.
 func (d *Dispatcher) DispatchIP() (*bufconn.Conn, error) {
-	if !d.ParallelDial || (d.tried < 1 && d.maxTry > 1) || statichost.HostIsIP(d.DestHost) {
-		c, err := net.DialTimeout("tcp", net.JoinHostPort(d.DestHost, d.DestPort), d.Timeout)
+	var IPs []string
+	if len(d.DestIP) > 0 {
+		IPs = []string{d.DestIP}
+	} else if statichost.HostIsIP(d.DestHost) {
+		IPs = []string{d.DestHost}
+	} else {
+		// DNS/host filtering results host to "0.0.0.0" or "127.0.0.1".
+		// For go, "0.0.0.0"/"::" are unspecified address that causes error. But "0.0.0.0" returns "0.0.0.0".
+		// We trust reliable DNS lookup results (:
+		var err error
+		IPs, err = d.LookupHost(d.DestHost)
 		if err != nil {
 			return nil, err
 		}
-		return bufconn.NewConn(c), nil
 	}
-
-	// DNS/host filtering results host to "0.0.0.0" or "127.0.0.1".
-	// For go, "0.0.0.0"/"::" are unspecified address that causes error. But "0.0.0.0" returns "0.0.0.0".
-	// We trust reliable DNS lookup results (:
-	IPs, err := net.LookupHost(d.DestHost)
-	if err != nil {
-		return nil, err
+	IPs = SortIPs(IPs, d.IPFamily)
+	if len(IPs) == 0 {
+		return nil, &net.DNSError{Err: "no address of the family: " + d.IPFamily, Name: d.DestHost, IsNotFound: true}
 	}
-
-	var goodConn goodConn
-	goodConn.n = 10 // max
-	if len(IPs) < goodConn.n {
-		goodConn.n = len(IPs)
-	}
-	start := int(time.Now().UnixMilli() % int64(len(IPs)))
-	waitChannel := make(chan struct{})
-	for i := 0; i < goodConn.n; i++ {
-		ip := IPs[(start+i)%goodConn.n]
-		go func() {
-			c, err := net.DialTimeout("tcp", net.JoinHostPort(ip, d.DestPort), d.Timeout)
-			goodConn.Lock()
-			if goodConn.c == nil {
-				if err == nil { // bad ip returns fast too
-					goodConn.c = c
-					close(waitChannel)
-				} else if goodConn.n == 1 { // return the last err (timeout)
-					goodConn.err = err
-					close(waitChannel)
-				}
-			} else if err == nil {
-				// ESTABLISHED
-				_ = c.SetDeadline(time.Now())
-				c.Close()
-			}
-			goodConn.n--
-			goodConn.Unlock()
-		}()
+	// The IPs are ranked per route.
+	h := d.directKey()
+	IPs = d.Engine.HostStats.RankIPs(h, IPs)
+	var report func(string, time.Duration, error)
+	if d.recordStats() {
+		report = func(ip string, latency time.Duration, err error) {
+			d.Engine.HostStats.UpdateIP(h, ip, err == nil, latency)
+		}
 	}
 
-	<-waitChannel
-	var conn *bufconn.Conn
-	goodConn.RLock()
-	if goodConn.c != nil {
-		conn = bufconn.NewConn(goodConn.c)
-	} else {
-		err = goodConn.err
+	delay := d.DialDelay
+	if !d.ParallelDial || (d.tried < 1 && d.maxTry > 1) {
+		delay = 0
 	}
-	goodConn.RUnlock()
-
-	return conn, err
+	c, err := DialHappyEyeballs(d.directDialer(), IPs, d.DestPort, delay, d.Timeout, report)
+	if err != nil {
+		return nil, err
+	}
+	return bufconn.NewConn(c), nil
 }
 
-// DispatchProxy gets the best proxy Conn.
+// DispatchProxy gets the best proxy Conn, of any upstream type that can carry the request.
 func (d *Dispatcher) DispatchProxy() (cs bufconn.ConnSolver, pp *proxypool.ProxyPool, p *proxypool.Proxy, err error) {
-	pp = GlobalProxyPool[d.ServerType]
-	if pp == nil {
+	p = d.getProxy()
+	if p == nil || p.URL == nil {
 		err = errors.New("no valid proxy")
 		return
 	}
-	p = pp.GetProxy(d.proxyTried)
-	if p.URL != nil {
-		switch d.ServerType {
-		case "http":
-			cs, err = bufconn.DialHTTP(p.URL, pp.Timeout)
-		case "socks5":
-			cs, err = bufconn.DialSocks5(p.URL, pp.Timeout)
-		case "socks4a":
-			cs, err = bufconn.DialSocks4a(p.URL, pp.Timeout)
-		}
-		if p.URL.User == nil {
-			return
-		}
-		c := cs.GetConn()
-		_ = c.SetDeadline(time.Now())
-		c.Close()
-		err = errors.New("proxy authentication is not implemented")
-	} else {
-		err = errors.New("no valid proxy")
+	pp = d.Engine.ProxyPool(p.URL.Scheme)
+	switch p.URL.Scheme {
+	case "http":
+		cs, err = bufconn.DialHTTP(d.Engine.proxyDialer(), p.URL, pp.Timeout)
+	case "socks5":
+		cs, err = bufconn.DialSocks5(d.Engine.proxyDialer(), p.URL, pp.Timeout)
+	case "socks4a":
+		cs, err = bufconn.DialSocks4a(d.Engine.proxyDialer(), p.URL, pp.Timeout)
+	}
+	if err != nil || p.URL.User == nil {
+		return
 	}
+	c := cs.GetConn()
+	_ = c.SetDeadline(time.Now())
+	c.Close()
+	err = errors.New("proxy authentication is not implemented")
 	return
 }
 
+// getProxy gets the sticky proxy of the host first, then the ranked ones except it.
+func (d *Dispatcher) getProxy() *proxypool.Proxy {
+	proxies := d.proxies()
+	n := len(proxies)
+	if n == 0 {
+		return nil
+	}
+	i := d.proxyTried
+	if i == 0 {
+		if u := d.Engine.Affinity.Get(d.ServerType, d.DestHost); u != "" {
+			for _, p := range proxies {
+				if p.URL.String() == u {
+					d.sticky = p
+					return p
+				}
+			}
+		}
+	} else if d.sticky != nil {
+		i--
+	}
+	p := proxies[i%n]
+	if p == d.sticky {
+		p = proxies[(i+1)%n]
+	}
+	return p
+}
+
 // ServeDirect serves the client by direct connection to the server.
 func (d *Dispatcher) ServeDirect(req protocol.Requester) (bool, error) {
 	client := d.Client
-	logPre := fmt.Sprintf("[%v] direct:%v/%v %v %v", d.ServerType, d.tried+1, d.maxTry, req.Command(), req.Host())
+	direct := "direct"
+	if r := d.route(); r != nil {
+		direct += "@" + r.Name
+	}
+	logPre := fmt.Sprintf("[%v] %v:%v/%v %v %v", d.ServerType, direct, d.tried+1, d.maxTry, req.Command(), req.Host())
 	_ = client.SetDeadline(time.Now().Add(2 * d.Timeout))
-	var leftTran forwarder.Transformer
+	var leftTran, rightTran forwarder.Transformer
+	replayable := false
 	if req.Command() == "CONNECT" {
 		err := req.GetRequest(client, client.R)
 		if err != nil {
 			log.Printf("%v <- %v <= TLS: no ClientHello, drop it.", logPre, client.RemoteAddr())
 			return true, err
 		}
+		// The local and the static direct hosts may use their own certificates.
+		if d.hello != nil && d.Engine.CertVerifier != nil && d.rule != statichost.StaticDirect {
+			rightTran = &protocol.CertInspector{Host: d.hello.ServerName, Verifier: d.Engine.CertVerifier}
+			// The ClientHello can be resent.
+			replayable = d.canReplay()
+		}
 	} else {
 		leftTran = &http.ReqestTransformer{}
+		// The local and the static direct hosts have nowhere else to go, a censor won't be there.
+		if d.rule != statichost.StaticDirect {
+			rightTran = &http.ResponseInspector{Host: d.DestHost, Signatures: d.Engine.Signatures}
+		}
+		if r, ok := req.(interface{ Replayable() bool }); ok {
+			replayable = r.Replayable() && d.canReplay()
+		}
 	}
 	restart := false
 	c, err := d.DispatchIP()
@@ -296,16 +426,25 @@ func (d *Dispatcher) ServeDirect(req protocol.Requester) (bool, error) {
 			wave = 1.0
 		}
 		fw := &forwarder.Forwarder{
-			LeftAddr:  client.RemoteAddr(),
-			LeftConn:  client,
-			LeftTran:  leftTran,
-			RightAddr: c.RemoteAddr(),
-			RightConn: c,
-			Timeout:   d.Timeout,
-			Wave:      wave,
+			LeftAddr:   client.RemoteAddr(),
+			LeftConn:   client,
+			LeftTran:   leftTran,
+			RightAddr:  c.RemoteAddr(),
+			RightConn:  c,
+			RightTran:  rightTran,
+			Timeout:    d.Timeout,
+			Wave:       wave,
+			Shaper:     d.Engine.Shaping.Shaper(client.RemoteAddr(), "", d.DestHost),
+			Replayable: replayable,
 		}
 		restart, err = req.Request(fw, false, d.tried == d.maxTry>>1)
+		fw.Shaper.Release()
 		c.Close()
+		if err != nil && !restart && d.recordStats() {
+			// Blackholed or reset after dialing up.
+			ip, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+			d.Engine.HostStats.UpdateIP(d.directKey(), ip, false, 0)
+		}
 	} else if IsDNSErr(err) {
 		// Trust the specified DNS.
 		// If the DNS isn't reliable enough, place a host in `blocked` to go proxied directly.
@@ -317,6 +456,9 @@ func (d *Dispatcher) ServeDirect(req protocol.Requester) (bool, error) {
 		} else {
 			_, err = client.Write([]byte("HTTP/1.1 569 DNS Orz\r\n\r\n"))
 		}
+	} else {
+		// Can't dial up, we may be offline.
+		d.Engine.Connectivity.Trigger()
 	}
 	if err != nil {
 		log.Printf("%v <= %v", logPre, err)
@@ -337,27 +479,43 @@ func (d *Dispatcher) ServeProxied(req protocol.Requester) (bool, error) {
 		}
 	}
 	restart := false
+	startTime := time.Now()
 	conn, pp, p, err := d.DispatchProxy()
 	if err == nil {
 		c := conn.GetConn()
 		log.Printf("%v => %v <-> %v <-> %v", logPre, client.RemoteAddr(), c.LocalAddr(), p.URL.Host)
-		err = conn.Bond(req.Command(), req.Hostname(), req.Port(), nil)
+		err = conn.Bond(bondCommand(req, p), d.DestHost, req.Port(), nil)
+		latency := time.Since(startTime)
 		if err == nil {
+			var leftTran forwarder.Transformer
+			if req.Command() != "CONNECT" && p.URL.Scheme != "http" {
+				// Tunneled by a socks proxy, the same as direct.
+				leftTran = &http.ReqestTransformer{}
+			}
 			fw := &forwarder.Forwarder{
 				LeftAddr:  client.RemoteAddr(),
 				LeftConn:  client,
+				LeftTran:  leftTran,
 				RightAddr: c.RemoteAddr(),
 				RightConn: c,
 				Timeout:   d.Timeout,
 				Wave:      1,
+				Shaper:    d.Engine.Shaping.Shaper(client.RemoteAddr(), p.URL.Host, d.DestHost),
 			}
-			restart, err = req.Request(fw, true, false)
+			restart, err = req.Request(fw, p.URL.Scheme == "http", false)
+			fw.Shaper.Release()
 		}
 		c.Close()
+		d.recordProxy(p, err == nil, latency)
 	}
-	if err != nil {
+	if err == nil {
+		d.Engine.Affinity.Remember(d.ServerType, d.DestHost, p.URL.String())
+	} else {
 		log.Printf("%v <= %v", logPre, err)
-		if globalOnline && p != nil {
+		if p != nil {
+			d.Engine.Affinity.Forget(d.ServerType, d.DestHost, p.URL.String())
+		}
+		if d.Engine.Connectivity.Online() && p != nil {
 			pp.UpdateProxy(p, 3*pp.Timeout)
 			if restart {
 				pp.Sort()
@@ -375,25 +533,3 @@ func NotInternetHost(h string) bool {
 	}
 	return !strings.Contains(h, ".")
 }
-
-// StartProbeDirect checks if we are online.
-func StartProbeDirect(url string, d time.Duration) {
-	globalOnline = true
-	ck, err := checker.New(url, d, "")
-	if err == nil {
-		go func() {
-			for {
-				globalOnline = ck.Check() == nil
-				msg := "offline"
-				if globalOnline {
-					msg = "online"
-				}
-				log.Printf("[dispatcher] We are %v.", msg)
-				time.Sleep(time.Minute)
-			}
-		}()
-		return
-	}
-	log.Print(err)
-	log.Printf("[dispatcher] No probing URL available, always act as online!")
-}
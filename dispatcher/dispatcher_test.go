@@ -0,0 +1,212 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol/http"
+	"github.com/lifenjoiner/pd/protocol/socks"
+	"github.com/lifenjoiner/pd/protocol/socks5"
+	"github.com/lifenjoiner/pd/proxypool"
+)
+
+// newFakeEngine makes an Engine on a fake network, the proxies go to the pools of their schemes.
+func newFakeEngine(proxies ...string) (*Engine, *network.Fake) {
+	fake := network.NewFake()
+	e := NewEngine()
+	e.Dialer = fake
+	e.Resolver = fake
+	if len(proxies) > 0 {
+		urls := make(map[string][]string)
+		for _, p := range proxies {
+			scheme := p[:strings.Index(p, ":")]
+			urls[scheme] = append(urls[scheme], p)
+		}
+		pools := make(map[string]*proxypool.ProxyPool)
+		for scheme, ps := range urls {
+			pools[scheme] = &proxypool.ProxyPool{Proxies: proxypool.NewProxies(ps), Timeout: 200 * time.Millisecond}
+		}
+		e.SetProxyPool(pools)
+	}
+	return e, fake
+}
+
+// dispatchGet dispatches a GET request from a piped client, and returns the response.
+func dispatchGet(t *testing.T, e *Engine, host string) (bool, string) {
+	r := bufio.NewReader(strings.NewReader("GET http://" + host + "/ HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	req, err := http.ParseRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	d := New(e, "http", bufconn.NewConn(server), host, "80", 200*time.Millisecond)
+	d.ParallelDial = true
+	d.DialDelay = 50 * time.Millisecond
+	done := make(chan bool)
+	go func() {
+		ok := d.Dispatch(req)
+		server.Close()
+		done <- ok
+	}()
+	b, _ := io.ReadAll(client)
+	return <-done, string(b)
+}
+
+func TestDispatchFakeNetwork(t *testing.T) {
+	e, fake := newFakeEngine()
+
+	fake.Hosts["example.com"] = []string{"192.0.2.1", "192.0.2.2"}
+	fake.Handle("192.0.2.1:80", network.Blackhole, nil)
+	fake.Handle("192.0.2.2:80", network.Accept, func(c net.Conn) {
+		r := bufio.NewReader(c)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+	})
+
+	// The 1st try dials the IPs one by one, and the blackholed one takes all the time.
+	// The 2nd try ranks the unknown IP before the failed one.
+	ok, resp := dispatchGet(t, e, "example.com")
+	if !ok || !strings.HasSuffix(resp, "\r\n\r\nok") {
+		t.Fatalf("dispatch: %v, %q", ok, resp)
+	}
+	if fake.Dials("192.0.2.1:80") != 1 || fake.Dials("192.0.2.2:80") != 1 {
+		t.Fatalf("dials: %v, %v", fake.Dials("192.0.2.1:80"), fake.Dials("192.0.2.2:80"))
+	}
+	stat := e.HostStats.GetStat("example.com:80")
+	if stat.Count != 2 || stat.IPs["192.0.2.1"].Fails != 1 || stat.IPs["192.0.2.2"].Value != 1 {
+		t.Fatalf("stat: %+v", stat)
+	}
+
+	// Refused by all routes without proxy.
+	fake.Hosts["down.example.com"] = []string{"192.0.2.3"}
+	ok, _ = dispatchGet(t, e, "down.example.com")
+	if ok || fake.Dials("192.0.2.3:80") != 3 || e.HostStats.GetStat("down.example.com:80").Value != 0 {
+		t.Fatal("should fail by all direct tries")
+	}
+
+	// Not found by the resolver, the DNS result is trusted.
+	_, resp = dispatchGet(t, e, "nx.example.com")
+	if !strings.HasPrefix(resp, "HTTP/1.1 569") {
+		t.Fatalf("should be a DNS error: %q", resp)
+	}
+}
+
+// serveHTTP responds to a request on the connection.
+func serveHTTP(resp string) func(net.Conn) {
+	return func(c net.Conn) {
+		r := bufio.NewReader(c)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = c.Write([]byte(resp))
+	}
+}
+
+func TestDispatchBlockedResponse(t *testing.T) {
+	e, fake := newFakeEngine("http://192.0.2.9:8080")
+	e.Signatures = &http.Signatures{}
+	e.Signatures.Upsert("text Access Denied by Censor")
+	fake.Handle("192.0.2.9:8080", network.Accept, serveHTTP("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nproxied"))
+
+	cases := map[string]func(net.Conn){
+		"redirect.example.com": serveHTTP("HTTP/1.1 302 Found\r\nLocation: http://10.10.34.34/?type=blocked\r\n\r\n"),
+		"page.example.com":     serveHTTP("HTTP/1.1 403 Forbidden\r\n\r\n<html>Access Denied by Censor</html>"),
+		"reset.example.com":    nil,
+	}
+	i := 10
+	for host, handler := range cases {
+		i++
+		ip := "192.0.2." + strconv.Itoa(i)
+		fake.Hosts[host] = []string{ip}
+		if handler == nil {
+			fake.Handle(ip+":80", network.Reset, nil)
+		} else {
+			fake.Handle(ip+":80", network.Accept, handler)
+		}
+		ok, resp := dispatchGet(t, e, host)
+		if !ok || !strings.HasSuffix(resp, "\r\n\r\nproxied") {
+			t.Fatalf("%v: %v, %q", host, ok, resp)
+		}
+		if fake.Dials(ip+":80") != 1 || e.HostStats.GetStat(host+":80").Value != 0 {
+			t.Fatalf("%v: should fail the direct try", host)
+		}
+	}
+
+	// A redirect to a domain is fine.
+	fake.Hosts["moved.example.com"] = []string{"192.0.2.20"}
+	fake.Handle("192.0.2.20:80", network.Accept, serveHTTP("HTTP/1.1 301 Moved\r\nLocation: https://moved.example.com/\r\n\r\n"))
+	ok, resp := dispatchGet(t, e, "moved.example.com")
+	if !ok || !strings.HasPrefix(resp, "HTTP/1.1 301") || e.HostStats.GetStat("moved.example.com:80").Value != 1 {
+		t.Fatalf("redirect: %v, %q", ok, resp)
+	}
+}
+
+func TestDispatchBlockedNoFallback(t *testing.T) {
+	e, fake := newFakeEngine()
+	redirect := serveHTTP("HTTP/1.1 302 Found\r\nLocation: http://10.10.34.34/\r\n\r\n")
+
+	// Without any proxy, the verdict doesn't drop the response.
+	fake.Hosts["redirect.example.com"] = []string{"192.0.2.11"}
+	fake.Handle("192.0.2.11:80", network.Accept, redirect)
+	_, resp := dispatchGet(t, e, "redirect.example.com")
+	if !strings.HasPrefix(resp, "HTTP/1.1 302") || fake.Dials("192.0.2.11:80") != 1 {
+		t.Fatalf("no proxy: %q", resp)
+	}
+
+	// A local host isn't inspected.
+	e, fake = newFakeEngine("http://192.0.2.9:8080")
+	fake.Handle("192.168.1.1:80", network.Accept, redirect)
+	ok, resp := dispatchGet(t, e, "192.168.1.1")
+	if !ok || !strings.HasPrefix(resp, "HTTP/1.1 302") || fake.Dials("192.0.2.9:8080") != 0 {
+		t.Fatalf("local: %v, %q", ok, resp)
+	}
+}
+
+func TestDispatchSniffedHost(t *testing.T) {
+	e, fake := newFakeEngine()
+	fake.Handle("203.0.113.5:443", network.Accept, func(c net.Conn) {
+		_, _ = c.Read(make([]byte, 1024))
+		_, _ = c.Write([]byte("ok"))
+	})
+
+	client, server := net.Pipe()
+	req := &socks5.Request{Ver: 5, Cmd: socks.CONNECT, DestHost: "203.0.113.5", DestPort: "443"}
+	d := New(e, "socks5", bufconn.NewConn(server), req.DestHost, req.DestPort, 200*time.Millisecond)
+	done := make(chan bool)
+	go func() {
+		ok := d.Dispatch(req)
+		server.Close()
+		done <- ok
+	}()
+	_, _ = client.Read(make([]byte, 10))
+	// A fake ClientHello with SNI "www.example.com".
+	hello := []byte("\x16\x03\x01\x00\x47\x01\x00\x00\x43\x03\x03" + strings.Repeat("\x00", 32) +
+		"\x00\x00\x02\x13\x01\x01\x00\x00\x18\x00\x00\x00\x14\x00\x12\x00\x00\x0fwww.example.com")
+	_, _ = client.Write(hello)
+	b, _ := io.ReadAll(client)
+	if !<-done || string(b) != "ok" {
+		t.Fatalf("dispatch: %q", b)
+	}
+	if d.DestHost != "www.example.com" || d.DestIP != "203.0.113.5" || e.HostStats.GetStat("www.example.com:443").Count != 1 {
+		t.Fatal("the stats should go to the sniffed host")
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"sync"
+
+	"github.com/lifenjoiner/pd/connectivity"
+	"github.com/lifenjoiner/pd/forwarder"
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol"
+	"github.com/lifenjoiner/pd/protocol/http"
+	"github.com/lifenjoiner/pd/proxypool"
+	"github.com/lifenjoiner/pd/resolver"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+// Engine holds what the Dispatchers decide and dial by, so independent instances can run in one process.
+// The nil Connectivity, Shaping, Breaker and Affinity are always online, unlimited, never open and not sticky.
+// The nil ProxyStats don't rank the proxies by destination, the nil UpstreamOrder is the DefaultUpstreamOrder.
+// The LearnedHosts apply only if the StaticHosts don't match.
+// The nil TLSRules and Signatures match nothing, the nil CertVerifier doesn't check.
+// The nil Dialer and Resolver are the system ones, the Resolvers by the static rules go first.
+// The Dialer dials the servers directly, the ProxyDialer dials the proxies, nil is the Dialer.
+// The Routes are the direct routes competing by the HostStats per route, nil is the single one by the Dialer.
+type Engine struct {
+	StaticHosts   statichost.StaticHosts
+	LearnedHosts  statichost.StaticHosts
+	TLSRules      statichost.TLSRules
+	Signatures    *http.Signatures
+	CertVerifier  *protocol.CertVerifier
+	HostStats     *hoststat.HostStats
+	ProxyStats    *hoststat.HostStats
+	Strategy      Strategy
+	Resolvers     map[statichost.Strategy]*resolver.Resolver
+	Connectivity  *connectivity.Monitor
+	Shaping       *forwarder.Shaping
+	Breaker       *Breaker
+	Affinity      *Affinity
+	UpstreamOrder []string
+	Routes        []*Route
+	Dialer        network.Dialer
+	ProxyDialer   network.Dialer
+	Resolver      network.Resolver
+	//local
+	mu        sync.RWMutex
+	proxyPool map[string]*proxypool.ProxyPool
+}
+
+// NewEngine generates an Engine with empty rules and stats, the default strategy and no proxy.
+func NewEngine() *Engine {
+	return &Engine{
+		StaticHosts: make(statichost.StaticHosts),
+		HostStats:   &hoststat.HostStats{Stats: make(map[string]*hoststat.HostStat)},
+		Strategy:    DefaultStrategy{},
+		Dialer:      network.SystemDialer,
+		Resolver:    network.SystemResolver,
+	}
+}
+
+// Globals packs the global parameters into an Engine, as the compatibility shim.
+func Globals() *Engine {
+	return &Engine{
+		StaticHosts:   GlobalStaticHosts,
+		LearnedHosts:  GlobalLearnedHosts,
+		TLSRules:      GlobalTLSRules,
+		Signatures:    GlobalSignatures,
+		CertVerifier:  GlobalCertVerifier,
+		HostStats:     GlobalHostStats,
+		ProxyStats:    GlobalProxyStats,
+		Strategy:      GlobalStrategy,
+		Resolvers:     GlobalResolvers,
+		Connectivity:  GlobalConnectivity,
+		Shaping:       GlobalShaping,
+		Breaker:       GlobalBreaker,
+		Affinity:      GlobalAffinity,
+		UpstreamOrder: GlobalUpstreamOrder,
+		Routes:        GlobalRoutes,
+		Dialer:        GlobalDialer,
+		ProxyDialer:   GlobalProxyDialer,
+		Resolver:      GlobalResolver,
+		proxyPool:     GlobalProxyPool,
+	}
+}
+
+// proxyDialer is the Dialer for the proxies.
+func (e *Engine) proxyDialer() network.Dialer {
+	if e.ProxyDialer != nil {
+		return e.ProxyDialer
+	}
+	return e.Dialer
+}
+
+// SetProxyPool sets the ProxyPools by the server types, they can be initialized later.
+func (e *Engine) SetProxyPool(pp map[string]*proxypool.ProxyPool) {
+	e.mu.Lock()
+	e.proxyPool = pp
+	e.mu.Unlock()
+}
+
+// ProxyPool gets the ProxyPool of a server type.
+func (e *Engine) ProxyPool(serverType string) *proxypool.ProxyPool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.proxyPool[serverType]
+}
+
+// ProxyPools gets all the ProxyPools.
+func (e *Engine) ProxyPools() []*proxypool.ProxyPool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	pools := make([]*proxypool.ProxyPool, 0, len(e.proxyPool))
+	for _, pp := range e.proxyPool {
+		pools = append(pools, pp)
+	}
+	return pools
+}
@@ -0,0 +1,85 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/connectivity"
+	"github.com/lifenjoiner/pd/forwarder"
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol"
+	"github.com/lifenjoiner/pd/protocol/http"
+	"github.com/lifenjoiner/pd/proxypool"
+	"github.com/lifenjoiner/pd/resolver"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+func TestEngine(t *testing.T) {
+	e1, e2 := NewEngine(), NewEngine()
+	e1.StaticHosts.Upsert("example.com", statichost.StaticBlocked)
+	e1.SetProxyPool(map[string]*proxypool.ProxyPool{"http": {}})
+
+	d1 := New(e1, "http", nil, "www.example.com", "443", time.Second)
+	d1.DispatchByStrategy(d1.DispatchByStaticRules())
+	d2 := New(e2, "http", nil, "www.example.com", "443", time.Second)
+	d2.DispatchByStrategy(d2.DispatchByStaticRules())
+	if d1.rule != statichost.StaticBlocked || d1.maxTry != 0 || d2.rule != statichost.StaticNil || d2.maxTry == 0 {
+		t.Fatal("the engines should be independent")
+	}
+	if e1.ProxyPool("http") == nil || e2.ProxyPool("http") != nil || len(e1.ProxyPools()) != 1 {
+		t.Fatal("the proxy pools should be independent")
+	}
+}
+
+func TestLearnedHosts(t *testing.T) {
+	e := NewEngine()
+	e.StaticHosts.Upsert("www.example.com", statichost.StaticDirect)
+	e.LearnedHosts = statichost.StaticHosts{}
+	e.LearnedHosts.Upsert("example.com", statichost.StaticBlocked)
+
+	for host, rule := range map[string]statichost.Strategy{
+		"www.example.com": statichost.StaticDirect,
+		"img.example.com": statichost.StaticBlocked,
+		"example.org":     statichost.StaticNil,
+	} {
+		if s := New(e, "http", nil, host, "443", time.Second).DispatchByStaticRules(); s != rule {
+			t.Errorf("%v: %v, want %v", host, s, rule)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	fake := network.NewFake()
+	GlobalStaticHosts = statichost.StaticHosts{}
+	GlobalLearnedHosts = statichost.StaticHosts{}
+	GlobalTLSRules = statichost.TLSRules{}
+	GlobalSignatures = &http.Signatures{}
+	GlobalCertVerifier = &protocol.CertVerifier{}
+	GlobalHostStats = &hoststat.HostStats{}
+	GlobalProxyStats = &hoststat.HostStats{}
+	GlobalResolvers = map[statichost.Strategy]*resolver.Resolver{}
+	GlobalConnectivity = &connectivity.Monitor{}
+	GlobalShaping = &forwarder.Shaping{}
+	GlobalBreaker = &Breaker{}
+	GlobalAffinity = &Affinity{}
+	GlobalUpstreamOrder = DefaultUpstreamOrder
+	GlobalRoutes = []*Route{}
+	GlobalDialer = fake
+	GlobalProxyDialer = fake
+	GlobalResolver = fake
+	GlobalProxyPool = map[string]*proxypool.ProxyPool{}
+
+	// Every parameter of an Engine has its global one.
+	e := reflect.ValueOf(Globals()).Elem()
+	for i := 0; i < e.NumField(); i++ {
+		if f := e.Type().Field(i); f.PkgPath == "" && e.Field(i).IsZero() || f.Name == "proxyPool" && e.Field(i).IsNil() {
+			t.Errorf("%v isn't from the globals", f.Name)
+		}
+	}
+}
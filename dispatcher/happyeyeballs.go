@@ -0,0 +1,149 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/lifenjoiner/pd/network"
+)
+
+// IP family preferences.
+const (
+	PreferIPv6 = "prefer-ipv6"
+	PreferIPv4 = "prefer-ipv4"
+	IPv4Only   = "ipv4-only"
+	IPv6Only   = "ipv6-only"
+)
+
+// SortIPs interleaves the IPv6 and IPv4 addresses by the family preference (RFC 8305 section 4).
+// The preferred family goes first, the other family may be filtered out.
+func SortIPs(IPs []string, family string) []string {
+	var v4, v6 []string
+	for _, ip := range IPs {
+		IP := net.ParseIP(ip)
+		if IP == nil {
+			continue
+		}
+		if IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	first, second := v6, v4
+	switch family {
+	case PreferIPv4:
+		first, second = v4, v6
+	case IPv4Only:
+		first, second = v4, nil
+	case IPv6Only:
+		second = nil
+	}
+	sorted := make([]string, 0, len(first)+len(second))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+	return sorted
+}
+
+// dialResult is the helper struct for DialHappyEyeballs.
+type dialResult struct {
+	c   net.Conn
+	err error
+}
+
+// The minimum timeout of a sequential dial, the same as the `net` package.
+const minSequentialTimeout = 2 * time.Second
+
+// partialTimeout divides the remaining time for the remaining addresses.
+func partialTimeout(remaining time.Duration, n int) time.Duration {
+	t := remaining / time.Duration(n)
+	if t < minSequentialTimeout {
+		t = minSequentialTimeout
+	}
+	if t > remaining {
+		t = remaining
+	}
+	return t
+}
+
+// DialHappyEyeballs dials the IPs in order, the next attempt starts after the delay or when the previous one fails.
+// The first established connection wins, and the others are canceled.
+// A delay <= 0 dials the IPs one by one within the timeout in total.
+// The result of each attempt but the canceled ones is reported, if report isn't nil.
+// A nil dialer is the system dialer.
+func DialHappyEyeballs(dialer network.Dialer, IPs []string, port string, delay, timeout time.Duration, report func(ip string, latency time.Duration, err error)) (net.Conn, error) {
+	if len(IPs) == 0 {
+		return nil, errors.New("no IP to dial")
+	}
+	if dialer == nil {
+		dialer = network.SystemDialer
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	deadline := time.Now().Add(timeout)
+	results := make(chan dialResult)
+	next, pending := 0, 0
+	var wait <-chan time.Time
+	start := func() {
+		ip := IPs[next]
+		t := timeout
+		if delay <= 0 {
+			t = partialTimeout(time.Until(deadline), len(IPs)-next)
+		} else {
+			wait = time.After(delay)
+		}
+		next++
+		pending++
+		go func() {
+			dctx, dcancel := context.WithTimeout(ctx, t)
+			defer dcancel()
+			startTime := time.Now()
+			c, err := dialer.DialContext(dctx, "tcp", net.JoinHostPort(ip, port))
+			if report != nil && ctx.Err() == nil {
+				report(ip, time.Since(startTime), err)
+			}
+			select {
+			case results <- dialResult{c, err}:
+			case <-ctx.Done():
+				if c != nil {
+					c.Close()
+				}
+			}
+		}()
+	}
+
+	var err error
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.c, nil
+			}
+			err = r.err
+			if next < len(IPs) && (delay > 0 || time.Now().Before(deadline)) {
+				start()
+			}
+		case <-wait:
+			wait = nil
+			if next < len(IPs) {
+				start()
+			}
+		}
+	}
+	return nil, err
+}
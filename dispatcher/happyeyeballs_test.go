@@ -0,0 +1,54 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortIPs(t *testing.T) {
+	IPs := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "::1", "::2"}
+	tests := map[string]string{
+		PreferIPv6: "::1,1.1.1.1,::2,2.2.2.2,3.3.3.3",
+		PreferIPv4: "1.1.1.1,::1,2.2.2.2,::2,3.3.3.3",
+		IPv4Only:   "1.1.1.1,2.2.2.2,3.3.3.3",
+		IPv6Only:   "::1,::2",
+	}
+	for family, expected := range tests {
+		sorted := strings.Join(SortIPs(IPs, family), ",")
+		if sorted != expected {
+			t.Errorf("%v: %v, expected = %v", family, sorted, expected)
+		}
+	}
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// 127.0.0.3 refuses, as only 127.0.0.1 is listened on.
+	for _, delay := range []time.Duration{0, 50 * time.Millisecond} {
+		c, err := DialHappyEyeballs(nil, []string{"127.0.0.3", "127.0.0.1"}, port, delay, time.Second, nil)
+		if err != nil {
+			t.Fatalf("delay %v: %v", delay, err)
+		}
+		if c.RemoteAddr().String() != l.Addr().String() {
+			t.Errorf("delay %v: connected to %v", delay, c.RemoteAddr())
+		}
+		c.Close()
+	}
+
+	_, err = DialHappyEyeballs(nil, []string{"127.0.0.3"}, port, 0, time.Second, nil)
+	if err == nil {
+		t.Fail()
+	}
+}
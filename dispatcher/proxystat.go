@@ -0,0 +1,75 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"time"
+
+	"github.com/lifenjoiner/pd/proxypool"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+// The ProxyStats are HostStats by the registrable domains, with the proxy URLs in place of the IPs.
+// So a proxy is ranked by its success and latency to the destination, rather than to the probe URL.
+
+// proxyStatKey is the destination key of the ProxyStats.
+func (d *Dispatcher) proxyStatKey() string {
+	return statichost.RegistrableDomain(d.DestHost) + ":" + d.DestPort
+}
+
+// proxies are the candidates of the request, ranked once per Dispatcher.
+// A proxy without scheme is in all the pools, it's a candidate once by the first scheme in the upstream order.
+func (d *Dispatcher) proxies() []*proxypool.Proxy {
+	if d.ranked == nil {
+		d.ranked = []*proxypool.Proxy{}
+		seen := make(map[string]bool)
+		for _, scheme := range d.Engine.upstreamOrder(d.ServerType) {
+			if pp := d.Engine.ProxyPool(scheme); pp != nil && canCarry(scheme, d.DestHost) {
+				for _, p := range d.rankProxies(pp) {
+					if !seen[p.URL.Host] {
+						seen[p.URL.Host] = true
+						d.ranked = append(d.ranked, p)
+					}
+				}
+			}
+		}
+	}
+	return d.ranked
+}
+
+// rankProxies orders the proxies of the pool by the ProxyStats of the destination:
+// the good ones by latency, then the unknown ones in the pool order, then the poor and the dropped ones.
+func (d *Dispatcher) rankProxies(pp *proxypool.ProxyPool) []*proxypool.Proxy {
+	proxies := pp.List()
+	if d.Engine.ProxyStats == nil || len(proxies) < 2 {
+		return proxies
+	}
+	byURL := make(map[string]*proxypool.Proxy, len(proxies))
+	urls := make([]string, len(proxies))
+	for i, p := range proxies {
+		urls[i] = p.URL.String()
+		byURL[urls[i]] = p
+	}
+	ranked := make([]*proxypool.Proxy, 0, len(proxies))
+	for _, u := range d.Engine.ProxyStats.RankIPs(d.proxyStatKey(), urls) {
+		ranked = append(ranked, byURL[u])
+		delete(byURL, u)
+	}
+	// The dropped ones are the last resorts.
+	for _, u := range urls {
+		if p := byURL[u]; p != nil {
+			ranked = append(ranked, p)
+		}
+	}
+	return ranked
+}
+
+// recordProxy feeds the result of a proxy to the destination into the ProxyStats.
+func (d *Dispatcher) recordProxy(p *proxypool.Proxy, ok bool, latency time.Duration) {
+	if d.Engine.ProxyStats == nil || p == nil || !d.Engine.Connectivity.Online() {
+		return
+	}
+	d.Engine.ProxyStats.UpdateIP(d.proxyStatKey(), p.URL.String(), ok, latency)
+}
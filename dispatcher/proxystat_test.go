@@ -0,0 +1,62 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+func TestDispatchProxyStats(t *testing.T) {
+	a, b := "http://192.0.2.8:8080", "http://192.0.2.9:8080"
+	e, fake := newFakeEngine(a, b)
+	e.StaticHosts["example.com"] = statichost.StaticBlocked
+	e.ProxyStats = &hoststat.HostStats{Stats: make(map[string]*hoststat.HostStat)}
+	resp := serveHTTP("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nproxied")
+	fake.Handle("192.0.2.8:8080", network.Accept, resp)
+	fake.Handle("192.0.2.9:8080", network.Accept, resp)
+
+	// The fastest proxy by the probe is poor for the domain.
+	e.ProxyStats.UpdateIP("example.com:80", a, false, 0)
+	if ok, _ := dispatchGet(t, e, "www.example.com"); !ok || fake.Dials("192.0.2.8:8080") != 0 || fake.Dials("192.0.2.9:8080") != 1 {
+		t.Fatal("should go by the unknown proxy first")
+	}
+	st := e.ProxyStats.GetStat("example.com:80").IPs[b]
+	if st == nil || st.Value != 1 || st.Latency <= 0 {
+		t.Fatalf("proxy stat: %+v", st)
+	}
+
+	// The other domains keep the pool order.
+	e.StaticHosts["example.org"] = statichost.StaticBlocked
+	if ok, _ := dispatchGet(t, e, "example.org"); !ok || fake.Dials("192.0.2.8:8080") != 1 {
+		t.Fatal("should go by the fastest proxy")
+	}
+}
+
+func TestProxyStatsSaved(t *testing.T) {
+	a := "http://192.0.2.8:8080"
+	e, fake := newFakeEngine(a)
+	e.StaticHosts["example.com"] = statichost.StaticBlocked
+	file := filepath.Join(t.TempDir(), "proxystats.json")
+	e.ProxyStats = &hoststat.HostStats{Validity: time.Hour}
+	e.ProxyStats.Load(file)
+	fake.Handle("192.0.2.8:8080", network.Accept, serveHTTP("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nproxied"))
+	if ok, _ := dispatchGet(t, e, "example.com"); !ok {
+		t.Fatal("dispatch")
+	}
+
+	// The stats recorded by the proxies survive the cleanup, and a restart.
+	e.ProxyStats.Save(file)
+	ps := &hoststat.HostStats{Validity: time.Hour}
+	ps.Load(file)
+	if st := ps.GetStat("example.com:80").IPs[a]; st == nil || st.Value != 1 {
+		t.Fatalf("loaded: %+v", ps.Stats)
+	}
+}
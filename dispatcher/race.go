@@ -0,0 +1,241 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/forwarder"
+	"github.com/lifenjoiner/pd/protocol"
+	"github.com/lifenjoiner/pd/proxypool"
+)
+
+// The direct wave a host below is ambiguous to race.
+const raceAmbiguousWave = 0.8
+
+// raceResult is the outcome of a racing route.
+type raceResult struct {
+	direct  bool
+	conn    *bufconn.Conn
+	pp      *proxypool.ProxyPool
+	p       *proxypool.Proxy
+	latency time.Duration
+	err     error
+}
+
+// route names the route of a result.
+func (r *raceResult) route() string {
+	if r.direct {
+		return "direct"
+	}
+	if r.p != nil {
+		return "proxy " + r.p.URL.Host
+	}
+	return "proxy"
+}
+
+// close the connection of a result.
+func (r *raceResult) close() {
+	if r.conn != nil {
+		_ = r.conn.SetDeadline(time.Now())
+		r.conn.Close()
+	}
+}
+
+// record feeds a result into the HostStats or the ProxyPool.
+func (d *Dispatcher) record(r *raceResult) {
+	if r.direct {
+		if d.recordStats() {
+			v := 0.0
+			if r.err == nil {
+				v = 1.0
+			}
+			d.recordDirect(v)
+		}
+		return
+	}
+	if r.p != nil && r.err != nil {
+		d.Engine.Affinity.Forget(d.ServerType, d.DestHost, r.p.URL.String())
+	}
+	if d.Engine.Connectivity.Online() && r.p != nil {
+		latency := r.latency
+		if r.err != nil {
+			latency = 3 * r.pp.Timeout
+		}
+		r.pp.UpdateProxy(r.p, latency)
+		d.recordProxy(r.p, r.err == nil, r.latency)
+	}
+}
+
+// shouldRace tells if a host is unknown or ambiguous to race a direct and a proxied connection.
+// The winner is tunneled as is, so it doesn't race if the certificate of the direct one is to be verified.
+func (d *Dispatcher) shouldRace(req protocol.Requester) bool {
+	return d.RaceDelay > 0 && req.Command() == "CONNECT" && d.maxTry > 0 && d.maxProxyTry > 0 &&
+		len(d.proxies()) > 0 && (d.hello == nil || d.Engine.CertVerifier == nil) &&
+		(d.stat.Count == 0 || d.directWave <= raceAmbiguousWave)
+}
+
+// waitResponse waits for the first response data from the server without consuming it.
+// For a ClientHello, it must be a handshake record, so an injected alert or a few bytes don't win.
+func (d *Dispatcher) waitResponse(c *bufconn.Conn) error {
+	_ = c.SetDeadline(time.Now().Add(d.Timeout))
+	if d.hello == nil {
+		_, err := c.R.Peek(1)
+		return err
+	}
+	b, err := c.R.Peek(5)
+	if err != nil {
+		return err
+	}
+	switch {
+	case b[0] == 0x16 && b[1] == 0x03:
+		return nil
+	case b[0] == 0x15:
+		return errors.New("TLS alert instead of ServerHello")
+	}
+	return errors.New("not a TLS handshake response")
+}
+
+// raceDirect dials up the server and sends the request.
+func (d *Dispatcher) raceDirect(req protocol.Requester) *raceResult {
+	r := &raceResult{direct: true}
+	startTime := time.Now()
+	r.conn, r.err = d.DispatchIP()
+	if r.err == nil {
+		r.err = req.Send(r.conn, false, false)
+		if r.err == nil {
+			r.err = d.waitResponse(r.conn)
+		}
+	}
+	r.latency = time.Since(startTime)
+	return r
+}
+
+// raceProxied dials up the best proxy and sends the request.
+func (d *Dispatcher) raceProxied(req protocol.Requester) *raceResult {
+	r := &raceResult{}
+	startTime := time.Now()
+	var cs bufconn.ConnSolver
+	cs, r.pp, r.p, r.err = d.DispatchProxy()
+	if r.err == nil {
+		r.conn = cs.GetConn()
+		r.err = cs.Bond(bondCommand(req, r.p), d.DestHost, req.Port(), nil)
+		if r.err == nil {
+			r.err = req.Send(r.conn, r.p.URL.Scheme == "http", false)
+			if r.err == nil {
+				r.err = d.waitResponse(r.conn)
+			}
+		}
+	}
+	r.latency = time.Since(startTime)
+	return r
+}
+
+// ServeRaced races a direct and a delayed proxied connection, commits to the first one the server responds.
+// Both outcomes are recorded. If both fail, it isn't done, and the rest tries go on.
+func (d *Dispatcher) ServeRaced(req protocol.Requester) (ok, done bool) {
+	client := d.Client
+	logPre := fmt.Sprintf("[%v] race %v %v", d.ServerType, req.Command(), req.Host())
+	_ = client.SetDeadline(time.Now().Add(2 * d.Timeout))
+	err := req.GetRequest(client, client.R)
+	if err != nil {
+		log.Printf("%v <- %v <= TLS: no ClientHello, drop it.", logPre, client.RemoteAddr())
+		return false, true
+	}
+
+	results := make(chan *raceResult, 2)
+	go func() {
+		results <- d.raceDirect(req)
+	}()
+	startProxied := func() {
+		go func() {
+			results <- d.raceProxied(req)
+		}()
+	}
+	delay := time.NewTimer(d.RaceDelay)
+	defer delay.Stop()
+
+	var winner *raceResult
+	pending := 1
+	proxyStarted := false
+	for pending > 0 && winner == nil {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				winner = r
+				continue
+			}
+			d.record(r)
+			r.close()
+			log.Printf("%v <= %v: %v", logPre, r.route(), r.err)
+			if !proxyStarted {
+				proxyStarted = true
+				pending++
+				startProxied()
+			}
+		case <-delay.C:
+			if !proxyStarted {
+				proxyStarted = true
+				pending++
+				startProxied()
+			}
+		}
+	}
+	if pending > 0 {
+		// The loser is recorded when it finishes.
+		go func() {
+			r := <-results
+			d.record(r)
+			r.close()
+		}()
+	}
+	if winner == nil {
+		d.tried = 1
+		d.proxyTried = 1
+		return false, false
+	}
+
+	c := winner.conn
+	log.Printf("%v => %v won in %v: %v <-> %v <-> %v", logPre, winner.route(), winner.latency, client.RemoteAddr(), c.LocalAddr(), c.RemoteAddr())
+	wave := 1.0
+	proxy := ""
+	if winner.direct {
+		wave = d.directWave
+	} else {
+		proxy = winner.p.URL.Host
+	}
+	fw := &forwarder.Forwarder{
+		LeftAddr:  client.RemoteAddr(),
+		LeftConn:  client,
+		RightAddr: c.RemoteAddr(),
+		RightConn: c,
+		Timeout:   d.Timeout,
+		Wave:      wave,
+		Shaper:    d.Engine.Shaping.Shaper(client.RemoteAddr(), proxy, d.DestHost),
+	}
+	restart, err := fw.Tunnel()
+	fw.Shaper.Release()
+	winner.close()
+	if err != nil {
+		log.Printf("%v <= %v", logPre, err)
+	}
+	winner.err = err
+	if winner.direct && restart {
+		d.record(winner)
+	}
+	d.record(winner)
+	if !winner.direct {
+		if err == nil {
+			d.Engine.Affinity.Remember(d.ServerType, d.DestHost, winner.p.URL.String())
+		}
+		winner.pp.Sort()
+	}
+	return err == nil, true
+}
@@ -0,0 +1,85 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol"
+	"github.com/lifenjoiner/pd/protocol/socks"
+	"github.com/lifenjoiner/pd/protocol/socks5"
+)
+
+func TestServeRacedAlert(t *testing.T) {
+	e, fake := newFakeEngine("http://192.0.2.9:8080")
+	// The direct one gets an injected alert at once.
+	fake.Hosts["www.example.com"] = []string{"192.0.2.1"}
+	fake.Handle("192.0.2.1:443", network.Accept, func(c net.Conn) {
+		_, _ = c.Read(make([]byte, 1024))
+		_, _ = c.Write([]byte("\x15\x03\x03\x00\x02\x02\x28"))
+	})
+	fake.Handle("192.0.2.9:8080", network.Accept, func(c net.Conn) {
+		r := bufio.NewReader(c)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		_, _ = r.Read(make([]byte, 1024))
+		_, _ = c.Write([]byte("\x16\x03\x03\x00\x01\x02"))
+	})
+
+	client, server := net.Pipe()
+	req := &socks5.Request{Ver: 5, Cmd: socks.CONNECT, DestHost: "www.example.com", DestPort: "443"}
+	d := New(e, "socks5", bufconn.NewConn(server), req.DestHost, req.DestPort, 200*time.Millisecond)
+	d.RaceDelay = 50 * time.Millisecond
+	done := make(chan bool)
+	go func() {
+		ok := d.Dispatch(req)
+		server.Close()
+		done <- ok
+	}()
+	_, _ = client.Read(make([]byte, 10))
+	// A fake ClientHello with SNI "www.example.com".
+	hello := []byte("\x16\x03\x01\x00\x47\x01\x00\x00\x43\x03\x03" + strings.Repeat("\x00", 32) +
+		"\x00\x00\x02\x13\x01\x01\x00\x00\x18\x00\x00\x00\x14\x00\x12\x00\x00\x0fwww.example.com")
+	_, _ = client.Write(hello)
+	b := make([]byte, 6)
+	_, _ = io.ReadFull(client, b)
+	client.Close()
+	if !<-done || b[0] != 0x16 {
+		t.Fatalf("the proxied one should win: %q", b)
+	}
+	if stat := e.HostStats.GetStat("www.example.com:443"); stat.Count != 1 || stat.Value != 0 {
+		t.Fatalf("the alert should fail the direct one: %+v", stat)
+	}
+}
+
+func TestShouldRace(t *testing.T) {
+	e, _ := newFakeEngine("http://192.0.2.9:8080")
+	req := &socks5.Request{Ver: 5, Cmd: socks.CONNECT, DestHost: "www.example.com", DestPort: "443"}
+	d := New(e, "socks5", nil, req.DestHost, req.DestPort, 200*time.Millisecond)
+	d.RaceDelay = 50 * time.Millisecond
+	d.maxTry = 1
+	d.maxProxyTry = 1
+	d.hello = &protocol.ClientHello{ServerName: "www.example.com"}
+	if !d.shouldRace(req) {
+		t.Fatal("an unknown host should race")
+	}
+	// The certificate can't be verified in the race.
+	e.CertVerifier = &protocol.CertVerifier{}
+	if d.shouldRace(req) {
+		t.Fatal("shouldn't race to verify the certificate")
+	}
+}
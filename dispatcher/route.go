@@ -0,0 +1,123 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/network"
+)
+
+// Route is a direct egress path, such as the uplink of an ISP. A nil Dialer is the Engine Dialer.
+type Route struct {
+	Name   string
+	Dialer network.Dialer
+}
+
+// ParseRoutes parses the direct routes "Name=Bind[;Name=Bind]...", Bind is the format of network.ParseBind.
+func ParseRoutes(s string) ([]*Route, error) {
+	var routes []*Route
+	names := make(map[string]bool)
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) < 2 || name == "" || strings.ContainsAny(name, "@:") {
+			return nil, fmt.Errorf("bad route: %v", item)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate route: %v", name)
+		}
+		names[name] = true
+		bd, err := network.ParseBind(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		r := &Route{Name: name}
+		if bd != nil {
+			r.Dialer = bd
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// directRoutes are the direct routes ranked by the HostStats of the destination, once per Dispatcher.
+// The rankings of more than one route are logged.
+func (d *Dispatcher) directRoutes() []*Route {
+	if d.routes != nil || len(d.Engine.Routes) == 0 {
+		return d.routes
+	}
+	h := d.DestHost + ":" + d.DestPort
+	byName := make(map[string]*Route, len(d.Engine.Routes))
+	names := make([]string, len(d.Engine.Routes))
+	for i, r := range d.Engine.Routes {
+		names[i] = r.Name
+		byName[r.Name] = r
+	}
+	d.routes = make([]*Route, 0, len(names))
+	var rankings []string
+	for _, name := range d.Engine.HostStats.RankRoutes(h, names) {
+		d.routes = append(d.routes, byName[name])
+		stat := d.Engine.HostStats.GetStat(hoststat.RouteKey(h, name))
+		rankings = append(rankings, fmt.Sprintf("%v %.2f/%v", name, stat.Value, stat.Count))
+	}
+	if len(d.routes) > 1 {
+		log.Printf("[dispatcher] %v routes: %v", h, strings.Join(rankings, ", "))
+	}
+	return d.routes
+}
+
+// route is the direct route of the current try, the tries go round the ranked routes. nil is the single default one.
+func (d *Dispatcher) route() *Route {
+	routes := d.directRoutes()
+	if len(routes) == 0 {
+		return nil
+	}
+	return routes[d.tried%len(routes)]
+}
+
+// directKey is the stat key of the destination by the route of the current try.
+func (d *Dispatcher) directKey() string {
+	h := d.DestHost + ":" + d.DestPort
+	if r := d.route(); r != nil {
+		return hoststat.RouteKey(h, r.Name)
+	}
+	return h
+}
+
+// directDialer is the Dialer of the route of the current try.
+func (d *Dispatcher) directDialer() network.Dialer {
+	if r := d.route(); r != nil && r.Dialer != nil {
+		return r.Dialer
+	}
+	return d.Engine.Dialer
+}
+
+// recordDirect feeds the result of a direct try into the HostStats of the destination and its route.
+func (d *Dispatcher) recordDirect(v float64) {
+	h := d.DestHost + ":" + d.DestPort
+	d.Engine.HostStats.Update(h, v)
+	if k := d.directKey(); k != h {
+		d.Engine.HostStats.Update(k, v)
+	}
+}
+
+// nextRoute tells if there is a direct route not tried yet, and makes sure it will be tried.
+func (d *Dispatcher) nextRoute() bool {
+	if d.tried+1 >= len(d.directRoutes()) {
+		return false
+	}
+	if d.maxTry < d.tried+2 {
+		d.maxTry = d.tried + 2
+	}
+	return true
+}
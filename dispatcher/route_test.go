@@ -0,0 +1,74 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol/http"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("isp1=; isp2=192.0.2.1,2001:db8::1")
+	if err != nil || len(routes) != 2 || routes[0].Dialer != nil || routes[1].Dialer == nil || routes[1].Name != "isp2" {
+		t.Fatalf("%v, %v", routes, err)
+	}
+	for _, s := range []string{"isp1", "=192.0.2.1", "isp1=;isp1=", "isp@1=", "isp1=192.0.2.1,192.0.2.2"} {
+		if _, err := ParseRoutes(s); err == nil {
+			t.Errorf("%q should fail", s)
+		}
+	}
+}
+
+func TestDispatchRoutes(t *testing.T) {
+	// The same server is reached by 2 ISPs, isp1 injects a block page.
+	e, isp1 := newFakeEngine()
+	isp2 := network.NewFake()
+	e.Signatures = &http.Signatures{}
+	e.Signatures.Upsert("text Access Denied by Censor")
+	e.Routes = []*Route{{Name: "isp1", Dialer: isp1}, {Name: "isp2", Dialer: isp2}}
+	isp1.Hosts["example.com"] = []string{"192.0.2.1"}
+	isp1.Handle("192.0.2.1:80", network.Accept, serveHTTP("HTTP/1.1 403 Forbidden\r\n\r\nAccess Denied by Censor"))
+	isp2.Handle("192.0.2.1:80", network.Accept, serveHTTP("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+
+	// Blocked by isp1, the next route goes before the proxies.
+	ok, resp := dispatchGet(t, e, "example.com")
+	if !ok || !strings.HasSuffix(resp, "\r\n\r\nok") {
+		t.Fatalf("dispatch: %v, %q", ok, resp)
+	}
+	h := "example.com:80"
+	if e.HostStats.GetStat(hoststat.RouteKey(h, "isp1")).Value != 0 || e.HostStats.GetStat(hoststat.RouteKey(h, "isp2")).Value != 1 ||
+		e.HostStats.GetStat(h).Count != 2 {
+		t.Fatalf("stats: %+v", e.HostStats.Stats)
+	}
+
+	// The best route goes first then.
+	ok, _ = dispatchGet(t, e, "example.com")
+	if !ok || isp1.Dials("192.0.2.1:80") != 1 || isp2.Dials("192.0.2.1:80") != 2 {
+		t.Fatalf("dials: %v, %v", isp1.Dials("192.0.2.1:80"), isp2.Dials("192.0.2.1:80"))
+	}
+}
+
+func TestDispatchRoutesFailover(t *testing.T) {
+	// isp1 blackholes the server, a single direct try goes on by isp2.
+	e, isp1 := newFakeEngine("http://192.0.2.9:8080")
+	isp2 := network.NewFake()
+	e.Strategy, _ = ParseThresholdStrategy("0:1", "", 1, 1)
+	e.Routes = []*Route{{Name: "isp1", Dialer: isp1}, {Name: "isp2", Dialer: isp2}}
+	isp1.Hosts["example.com"] = []string{"192.0.2.1"}
+	isp1.Handle("192.0.2.1:80", network.Blackhole, nil)
+	isp2.Handle("192.0.2.1:80", network.Accept, serveHTTP("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+
+	ok, resp := dispatchGet(t, e, "example.com")
+	if !ok || !strings.HasSuffix(resp, "\r\n\r\nok") || isp1.Dials("192.0.2.9:8080") != 0 {
+		t.Fatalf("dispatch: %v, %q", ok, resp)
+	}
+	if isp1.Dials("192.0.2.1:80") != 1 || isp2.Dials("192.0.2.1:80") != 1 {
+		t.Fatalf("dials: %v, %v", isp1.Dials("192.0.2.1:80"), isp2.Dials("192.0.2.1:80"))
+	}
+}
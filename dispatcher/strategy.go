@@ -0,0 +1,198 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+// Condition is what a Strategy makes the Plan by.
+// Online is the state of the connectivity monitor, offline the direct tries are skipped except for static direct hosts.
+type Condition struct {
+	Stat   hoststat.HostStat
+	Rule   statichost.Strategy
+	Online bool
+}
+
+// Plan is the direct and proxied tries for a host.
+type Plan struct {
+	MaxTry      int
+	MaxProxyTry int
+	DirectWave  float64
+}
+
+// Strategy plans how a host will be tried.
+type Strategy interface {
+	Plan(cond Condition) Plan
+}
+
+// DefaultStrategy is the built-in Strategy.
+type DefaultStrategy struct{}
+
+// Plan by static rules and the connectivity, then by the stat value and the time since the last try.
+func (DefaultStrategy) Plan(cond Condition) (p Plan) {
+	p.DirectWave = 1
+	switch cond.Rule {
+	case statichost.StaticDirect:
+		p.MaxTry = 3
+		return
+	case statichost.StaticBlocked:
+		p.MaxProxyTry = 3
+		return
+	}
+	p.MaxProxyTry = 3
+
+	if !cond.Online {
+		return
+	}
+
+	stat := cond.Stat
+	if stat.Count == 0 {
+		stat.Value = 1
+	}
+
+	v := stat.Value
+	p.DirectWave = v
+
+	if v > 0.8 {
+		p.MaxTry = 3
+	} else if v > 0.6 {
+		p.MaxTry = 2
+	} else if v > 0.4 || stat.Count <= hoststat.EwmaSlide {
+		p.MaxTry = 1
+	} else {
+		dt := time.Since(stat.Time)
+		if v > 0.3 && dt > 5*time.Minute {
+			p.MaxTry = 1
+		} else if v > 0.2 && dt > 7*time.Minute {
+			p.MaxTry = 1
+		} else if v > 0.1 && dt > 13*time.Minute {
+			p.MaxTry = 1
+		} else if dt > 31*time.Minute {
+			p.MaxTry = 1
+		} else {
+			p.MaxTry = 0
+		}
+	}
+	return
+}
+
+// Threshold is a step of the threshold table: a host above Value gets Tries direct tries.
+type Threshold struct {
+	Value float64
+	Tries int
+}
+
+// Backoff is a step of the back-off table: a poor host above Value gets a direct try after Wait.
+type Backoff struct {
+	Value float64
+	Wait  time.Duration
+}
+
+// ThresholdStrategy is a configurable Strategy.
+// The steps of Thresholds and Backoffs are checked in order, the last back-off step with Value <= 0 matches any value.
+// A static direct host gets MaxTry direct tries.
+type ThresholdStrategy struct {
+	Thresholds  []Threshold
+	Backoffs    []Backoff
+	MaxTry      int
+	MaxProxyTry int
+}
+
+// Plan by static rules and the connectivity, then by the thresholds and the back-off table.
+func (s *ThresholdStrategy) Plan(cond Condition) (p Plan) {
+	p.DirectWave = 1
+	switch cond.Rule {
+	case statichost.StaticDirect:
+		p.MaxTry = s.MaxTry
+		return
+	case statichost.StaticBlocked:
+		p.MaxProxyTry = s.MaxProxyTry
+		return
+	}
+	p.MaxProxyTry = s.MaxProxyTry
+
+	if !cond.Online {
+		return
+	}
+
+	stat := cond.Stat
+	if stat.Count == 0 {
+		stat.Value = 1
+	}
+
+	v := stat.Value
+	p.DirectWave = v
+
+	for _, t := range s.Thresholds {
+		if v > t.Value {
+			p.MaxTry = t.Tries
+			return
+		}
+	}
+	if stat.Count <= hoststat.EwmaSlide {
+		p.MaxTry = 1
+		return
+	}
+	dt := time.Since(stat.Time)
+	for _, b := range s.Backoffs {
+		if (v > b.Value || b.Value <= 0) && dt > b.Wait {
+			p.MaxTry = 1
+			return
+		}
+	}
+	return
+}
+
+// ParseThresholdStrategy parses the threshold table "0.8:3,0.6:2,0.4:1" and the back-off table "0.3:5m,0.2:7m,0.1:13m,0:31m".
+func ParseThresholdStrategy(thresholds, backoffs string, maxTry, maxProxyTry int) (*ThresholdStrategy, error) {
+	s := &ThresholdStrategy{MaxTry: maxTry, MaxProxyTry: maxProxyTry}
+	for _, t := range strings.Split(thresholds, ",") {
+		if len(t) == 0 {
+			continue
+		}
+		vn := strings.SplitN(t, ":", 2)
+		if len(vn) != 2 {
+			return nil, errors.New("invalid threshold step: " + t)
+		}
+		v, err := strconv.ParseFloat(vn[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(vn[1])
+		if err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, errors.New("invalid direct tries: " + t)
+		}
+		s.Thresholds = append(s.Thresholds, Threshold{v, n})
+	}
+	for _, b := range strings.Split(backoffs, ",") {
+		if len(b) == 0 {
+			continue
+		}
+		vw := strings.SplitN(b, ":", 2)
+		if len(vw) != 2 {
+			return nil, errors.New("invalid back-off step: " + b)
+		}
+		v, err := strconv.ParseFloat(vw[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		w, err := time.ParseDuration(vw[1])
+		if err != nil {
+			return nil, err
+		}
+		s.Backoffs = append(s.Backoffs, Backoff{v, w})
+	}
+	return s, nil
+}
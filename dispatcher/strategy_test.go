@@ -0,0 +1,71 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+func TestThresholdStrategy(t *testing.T) {
+	ts, err := ParseThresholdStrategy("0.8:3,0.6:2,0.4:1", "0.3:5m,0.2:7m,0.1:13m,0:31m", 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := DefaultStrategy{}
+
+	values := []float64{0, 0.05, 0.15, 0.25, 0.35, 0.5, 0.7, 0.9, 1}
+	counts := []int{0, 5, 20}
+	ages := []time.Duration{time.Minute, 6 * time.Minute, 8 * time.Minute, 14 * time.Minute, time.Hour}
+	rules := []statichost.Strategy{statichost.StaticNil, statichost.StaticDirect, statichost.StaticBlocked}
+	for _, r := range rules {
+		for _, v := range values {
+			for _, n := range counts {
+				for _, a := range ages {
+					cond := Condition{
+						Stat:   hoststat.HostStat{Value: v, Count: n, Time: time.Now().Add(-a)},
+						Rule:   r,
+						Online: true,
+					}
+					if pt, pd := ts.Plan(cond), ds.Plan(cond); pt != pd {
+						t.Errorf("%v %v %v %v: %v, expected = %v", r, v, n, a, pt, pd)
+					}
+				}
+			}
+		}
+	}
+
+	for _, c := range [][2]string{{"0.8:3", "0.3"}, {"0.8", "0.3:5m"}, {"0.8:0", "0.3:5m"}} {
+		if _, err = ParseThresholdStrategy(c[0], c[1], 3, 3); err == nil {
+			t.Errorf("%v should fail", c)
+		}
+	}
+
+	// The direct tries are as configured, rather than by the count of the thresholds.
+	ts, _ = ParseThresholdStrategy("0.5:4", "", 2, 3)
+	if p := ts.Plan(Condition{Rule: statichost.StaticDirect}); p.MaxTry != 2 {
+		t.Errorf("static direct: %v", p)
+	}
+	if p := ts.Plan(Condition{Stat: hoststat.HostStat{Value: 0.6, Count: 20}, Online: true}); p.MaxTry != 4 {
+		t.Errorf("above 0.5: %v", p)
+	}
+}
+
+func TestStrategyOffline(t *testing.T) {
+	ts, _ := ParseThresholdStrategy("0.8:3,0.6:2,0.4:1", "0:31m", 3, 3)
+	for _, s := range []Strategy{DefaultStrategy{}, ts} {
+		// Offline, a good host goes proxied only.
+		if p := s.Plan(Condition{Stat: hoststat.HostStat{Value: 0.9, Count: 20}}); p.MaxTry != 0 || p.MaxProxyTry != 3 {
+			t.Errorf("%T offline: %v", s, p)
+		}
+		// A static direct host may be local.
+		if p := s.Plan(Condition{Rule: statichost.StaticDirect}); p.MaxTry != 3 {
+			t.Errorf("%T offline static direct: %v", s, p)
+		}
+	}
+}
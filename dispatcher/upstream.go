@@ -0,0 +1,74 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lifenjoiner/pd/protocol"
+	"github.com/lifenjoiner/pd/proxypool"
+)
+
+// SameUpstream in an upstream order is the protocol of the client.
+const SameUpstream = "same"
+
+// DefaultUpstreamOrder is the upstream types a request goes by in order: the client protocol, then the translated ones.
+var DefaultUpstreamOrder = []string{SameUpstream, "socks5", "http", "socks4a"}
+
+// ParseUpstreamOrder parses the comma separated upstream types.
+func ParseUpstreamOrder(s string) ([]string, error) {
+	var order []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.TrimSpace(t)
+		switch t {
+		case SameUpstream, "http", "socks5", "socks4a":
+			order = append(order, t)
+		default:
+			return nil, fmt.Errorf("unknown upstream type: %q", t)
+		}
+	}
+	return order, nil
+}
+
+// upstreamOrder gets the upstream types for a client protocol, without duplication.
+func (e *Engine) upstreamOrder(serverType string) []string {
+	order := e.UpstreamOrder
+	if order == nil {
+		order = DefaultUpstreamOrder
+	}
+	seen := make(map[string]bool)
+	types := make([]string, 0, len(order))
+	for _, t := range order {
+		if t == SameUpstream {
+			t = serverType
+		}
+		if !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// canCarry tells if an upstream type can express the destination host.
+// socks4a takes the host as a name, an IPv6 isn't one. socks5 takes a name up to 255 bytes.
+func canCarry(scheme, host string) bool {
+	switch scheme {
+	case "socks4a":
+		return !strings.Contains(host, ":") && len(host) <= 255
+	case "socks5":
+		return len(host) <= 255
+	}
+	return true
+}
+
+// bondCommand is the command to bond a proxy with, a socks proxy tunnels the plain HTTP requests by CONNECT.
+func bondCommand(req protocol.Requester, p *proxypool.Proxy) string {
+	if p.URL.Scheme != "http" {
+		return "CONNECT"
+	}
+	return req.Command()
+}
@@ -0,0 +1,115 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package dispatcher
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol/socks"
+	"github.com/lifenjoiner/pd/protocol/socks5"
+	"github.com/lifenjoiner/pd/proxypool"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+func TestUpstreamOrder(t *testing.T) {
+	if _, err := ParseUpstreamOrder("same,ftp"); err == nil {
+		t.Fatal("unknown type")
+	}
+	e := NewEngine()
+	e.UpstreamOrder, _ = ParseUpstreamOrder("same,http,socks5,socks4a")
+	if order := strings.Join(e.upstreamOrder("socks5"), ","); order != "socks5,http,socks4a" {
+		t.Fatalf("order: %v", order)
+	}
+	if !canCarry("socks4a", "192.0.2.1") || canCarry("socks4a", "2001:db8::1") || !canCarry("socks5", "2001:db8::1") {
+		t.Fatal("socks4a can't carry IPv6")
+	}
+
+	// A proxy without scheme is a candidate once, by the first scheme.
+	e, _ = newFakeEngine("http://192.0.2.8:8080", "socks5://192.0.2.8:8080", "socks4a://192.0.2.8:8080", "socks4a://192.0.2.9:1080")
+	e.UpstreamOrder, _ = ParseUpstreamOrder("same,http,socks5,socks4a")
+	d := New(e, "socks5", nil, "example.com", "443", time.Second)
+	var candidates []string
+	for _, p := range d.proxies() {
+		candidates = append(candidates, p.URL.String())
+	}
+	if strings.Join(candidates, ",") != "socks5://192.0.2.8:8080,socks4a://192.0.2.9:1080" {
+		t.Fatalf("candidates: %v", candidates)
+	}
+}
+
+func TestDispatchCrossProtocol(t *testing.T) {
+	e, fake := newFakeEngine("http://192.0.2.8:8080", "socks4a://192.0.2.9:1080")
+	e.StaticHosts["example.com"] = statichost.StaticBlocked
+	e.StaticHosts["2001:db8::1"] = statichost.StaticBlocked
+	var got string
+	fake.Handle("192.0.2.8:8080", network.Accept, func(c net.Conn) {
+		r := bufio.NewReader(c)
+		got, _ = r.ReadString('\n')
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = c.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		_, _ = r.Read(make([]byte, 1024))
+		_, _ = c.Write([]byte("ok"))
+	})
+
+	// A socks5 client goes by the http upstream.
+	client, server := net.Pipe()
+	req := &socks5.Request{Ver: 5, Cmd: socks.CONNECT, DestHost: "example.com", DestPort: "443"}
+	d := New(e, "socks5", bufconn.NewConn(server), req.DestHost, req.DestPort, 200*time.Millisecond)
+	done := make(chan bool)
+	go func() {
+		ok := d.Dispatch(req)
+		server.Close()
+		done <- ok
+	}()
+	_, _ = client.Read(make([]byte, 10))
+	_, _ = client.Write([]byte("hello"))
+	b, _ := io.ReadAll(client)
+	if !<-done || string(b) != "ok" || got != "CONNECT example.com:443 HTTP/1.1\r\n" {
+		t.Fatalf("dispatch: %q, %q", b, got)
+	}
+
+	// socks4a can't carry IPv6, the http upstream is the only candidate.
+	d = New(e, "socks4a", nil, "2001:db8::1", "443", time.Second)
+	if proxies := d.proxies(); len(proxies) != 1 || proxies[0].URL.Scheme != "http" {
+		t.Fatalf("candidates: %v", proxies)
+	}
+
+	// A plain HTTP request goes by the socks5 upstream in the origin form.
+	e.SetProxyPool(map[string]*proxypool.ProxyPool{
+		"socks5": {Proxies: proxypool.NewProxies([]string{"socks5://192.0.2.10:1080"}), Timeout: 200 * time.Millisecond},
+	})
+	fake.Handle("192.0.2.10:1080", network.Accept, func(c net.Conn) {
+		b := make([]byte, 1024)
+		_, _ = c.Read(b)
+		_, _ = c.Write([]byte{5, 0})
+		_, _ = c.Read(b)
+		_, _ = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		r := bufio.NewReader(c)
+		got, _ = r.ReadString('\n')
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		_, _ = c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nproxied"))
+	})
+	ok, resp := dispatchGet(t, e, "example.com")
+	if !ok || !strings.HasSuffix(resp, "proxied") || got != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("dispatch: %q, %q", resp, got)
+	}
+}
@@ -13,7 +13,7 @@ func isTimeout(err error) bool {
 }
 
 func isReset(err error) bool {
-	return err != nil && strings.Contains(err.Error(), "forcibly closed")
+	return err != nil && (strings.Contains(err.Error(), "forcibly closed") || strings.Contains(err.Error(), "connection reset"))
 }
 
 func isEOF(err error) bool {
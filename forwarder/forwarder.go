@@ -7,8 +7,10 @@ package forwarder
 
 import (
 	//"log"
+	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lifenjoiner/pd/bufconn"
@@ -24,6 +26,9 @@ type Forwarder struct {
 	RightTran Transformer
 	Timeout   time.Duration
 	Wave      float64
+	Shaper    *Shaper
+	// Replayable tells if the request can be resent by another route, then a blocked response is dropped.
+	Replayable bool
 }
 
 // The reading size, could > 4k, need big enough to get the whole TLS Handshake packets.
@@ -60,6 +65,8 @@ const (
 // Tunnel operates the communication.
 func (fw *Forwarder) Tunnel() (bool, error) {
 	var wg sync.WaitGroup
+	// mu guards the errors, the timeouts and the TLS stage shared by the 2 directions.
+	var mu sync.Mutex
 	var LrErr, LwErr, RrErr, RwErr error
 
 	LeftTimeout := 2 * fw.Timeout
@@ -69,25 +76,67 @@ func (fw *Forwarder) Tunnel() (bool, error) {
 	RightTLSAlive := LeftTLSAlive + fw.Timeout
 	TLSStageRight := byte(0)
 	gotRightData := false
+	var up, down []*Bucket
+	if fw.Shaper != nil {
+		up, down = fw.Shaper.Up, fw.Shaper.Down
+	}
+	inspector, inspecting := fw.RightTran.(Inspector)
+	var held []byte
+	var blocked error
+	dropped := false
+	var leftSent int32
+
+	timeouts := func() (time.Duration, time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		return LeftTimeout, RightTimeout
+	}
+	keepAlive := func() {
+		mu.Lock()
+		LeftTimeout = LeftTLSAlive
+		RightTimeout = RightTLSAlive
+		mu.Unlock()
+	}
+	setStage := func(stage byte) {
+		mu.Lock()
+		TLSStageRight = stage
+		mu.Unlock()
+	}
+	setErr := func(e *error, err error) {
+		mu.Lock()
+		*e = err
+		mu.Unlock()
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return LrErr != nil || LwErr != nil || RrErr != nil || RwErr != nil
+	}
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		var n int
+		var lrErr error
 		leftBufPtr := bufPool.Get().(*[]byte)
 		LeftBuf := *leftBufPtr
 		for {
 			if x := cap(LeftBuf); n == x && x < maxBufferSize {
 				LeftBuf = make([]byte, x+minBufferSize)
 			}
-			_ = fw.LeftConn.SetDeadline(time.Now().Add(LeftTimeout))
-			n, LrErr = fw.LeftConn.R.Read(LeftBuf)
-			if LrErr == nil {
-				if TLSStageRight == TLSHandshake && LeftBuf[0] == TLSApplication && n > 1 && LeftBuf[1] == 0x03 {
+			leftTimeout, rightTimeout := timeouts()
+			_ = fw.LeftConn.SetDeadline(time.Now().Add(leftTimeout))
+			n, lrErr = fw.LeftConn.R.Read(LeftBuf)
+			mu.Lock()
+			LrErr = lrErr
+			stage := TLSStageRight
+			mu.Unlock()
+			if lrErr == nil {
+				if stage == TLSHandshake && LeftBuf[0] == TLSApplication && n > 1 && LeftBuf[1] == 0x03 {
 					// Request data is sent. Some server may response slowly: snapshot downloading from https://repo.or.cz
 					//log.Printf("[forwarder] TLS Application data is got: %v --> %v", fw.LeftAddr, fw.RightAddr)
-					LeftTimeout = LeftTLSAlive
-					RightTimeout = RightTLSAlive
+					keepAlive()
+					_, rightTimeout = timeouts()
 				}
 				//log.Printf("[forwarder] %v --> %v Read: %v", fw.LeftAddr, fw.RightAddr, n)
 				data := LeftBuf[0:n]
@@ -97,15 +146,16 @@ func (fw *Forwarder) Tunnel() (bool, error) {
 						data = d
 					}
 				}
-				_ = fw.RightConn.SetDeadline(time.Now().Add(RightTimeout))
-				_, RwErr = fw.RightConn.Write(data)
+				atomic.StoreInt32(&leftSent, 1)
+				setErr(&RwErr, shapedWrite(fw.RightConn, data, rightTimeout, up))
 			}
-			if LrErr != nil || LwErr != nil || RrErr != nil || RwErr != nil {
-				if isReset(LrErr) || isTimeout(LrErr) {
+			if failed() {
+				if isReset(lrErr) || isTimeout(lrErr) {
 					_ = fw.RightConn.SetDeadline(time.Now())
 				}
-				_ = fw.LeftConn.SetDeadline(time.Now().Add(LeftTimeout))
-				//log.Printf("[forwarder] %v --> %v: %v", fw.LeftAddr, fw.RightAddr, LrErr)
+				leftTimeout, _ = timeouts()
+				_ = fw.LeftConn.SetDeadline(time.Now().Add(leftTimeout))
+				//log.Printf("[forwarder] %v --> %v: %v", fw.LeftAddr, fw.RightAddr, lrErr)
 				break
 			}
 		}
@@ -114,18 +164,22 @@ func (fw *Forwarder) Tunnel() (bool, error) {
 	}()
 
 	var n int
+	var rrErr error
 	rightBufPtr := bufPool.Get().(*[]byte)
 	RightBuf := *rightBufPtr
 	for {
 		if x := cap(RightBuf); n == x && x < maxBufferSize {
 			RightBuf = make([]byte, x+minBufferSize)
 		}
-		_ = fw.RightConn.SetDeadline(time.Now().Add(RightTimeout))
-		n, RrErr = fw.RightConn.R.Read(RightBuf)
-		if RrErr == nil {
+		_, rightTimeout := timeouts()
+		_ = fw.RightConn.SetDeadline(time.Now().Add(rightTimeout))
+		n, rrErr = fw.RightConn.R.Read(RightBuf)
+		setErr(&RrErr, rrErr)
+		if rrErr == nil {
 			// RightBuf has enough space.
+			// TLSStageRight is written by this direction only.
 			if TLSStageRight == 0x00 {
-				TLSStageRight = RightBuf[0]
+				setStage(RightBuf[0])
 				if !(RightBuf[0] == TLSHandshake && n > 1 && RightBuf[1] == 0x03) {
 					gotRightData = true
 					//} else {
@@ -138,15 +192,13 @@ func (fw *Forwarder) Tunnel() (bool, error) {
 				if (RightBuf[0] == TLSHandshake || RightBuf[0] == TLSChangeCipher) && n > 1 && RightBuf[1] == 0x03 {
 					// TLS v1.2, a: [NewSessionTicket + ]ChangeCipherSpec + EncryptedHandshakeMessage
 					// Weixin server sleeps (25s) before sending application data for heartbeats.
-					LeftTimeout = LeftTLSAlive
-					RightTimeout = RightTLSAlive
+					keepAlive()
 				} else if RightBuf[0] == TLSApplication && n > 1 && RightBuf[1] == 0x03 {
 					// Response data is received.
 					//log.Printf("[forwarder] TLS Application data is got: %v <-- %v", fw.LeftAddr, fw.RightAddr)
-					TLSStageRight = TLSApplication
+					setStage(TLSApplication)
 					gotRightData = true
-					LeftTimeout = LeftTLSAlive
-					RightTimeout = RightTLSAlive
+					keepAlive()
 				}
 			}
 			data := RightBuf[0:n]
@@ -156,21 +208,54 @@ func (fw *Forwarder) Tunnel() (bool, error) {
 					data = d
 				}
 			}
-			_ = fw.LeftConn.SetDeadline(time.Now().Add(LeftTimeout))
-			_, LwErr = fw.LeftConn.Write(data)
+			if inspecting {
+				held = append(held, data...)
+				data = nil
+				if done, err := inspector.Verdict(); done {
+					inspecting = false
+					data, held = held, nil
+					if err != nil {
+						blocked = err
+						if fw.Replayable && atomic.LoadInt32(&leftSent) == 0 {
+							dropped = true
+							break
+						}
+					}
+				}
+			}
+			if len(data) > 0 {
+				leftTimeout, _ := timeouts()
+				setErr(&LwErr, shapedWrite(fw.LeftConn, data, leftTimeout, down))
+			}
+		} else if inspecting {
+			if len(held) > 0 {
+				// Undecided before the server closes, let it go.
+				leftTimeout, _ := timeouts()
+				setErr(&LwErr, shapedWrite(fw.LeftConn, held, leftTimeout, down))
+			} else if isReset(rrErr) {
+				blocked = fmt.Errorf("%w: reset before responding", ErrBlocked)
+				dropped = fw.Replayable && atomic.LoadInt32(&leftSent) == 0
+			}
 		}
-		if LrErr != nil || LwErr != nil || RrErr != nil || RwErr != nil {
+		if failed() {
 			_ = fw.LeftConn.SetDeadline(time.Now())
-			//log.Printf("[forwarder] %v <-- %v: %v", fw.LeftAddr, fw.RightAddr, RrErr)
+			//log.Printf("[forwarder] %v <-- %v: %v", fw.LeftAddr, fw.RightAddr, rrErr)
 			break
 		}
 	}
 	*rightBufPtr = RightBuf
 	bufPool.Put(rightBufPtr)
+	if dropped {
+		_ = fw.LeftConn.SetDeadline(time.Now())
+	}
 	wg.Wait()
 
 	_ = fw.RightConn.SetDeadline(time.Now())
 	_ = fw.LeftConn.SetDeadline(time.Now())
+	if blocked != nil {
+		// If the response is dropped, the client can be served by another route.
+		return !dropped, blocked
+	}
 	ok := gotRightData || isReset(LrErr) || isEOF(LrErr)
 	//log.Print(LrErr)
 	//log.Print(LwErr)
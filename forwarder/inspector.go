@@ -0,0 +1,23 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBlocked is the verdict on a response injected by a censor, such as a block page or an immediate reset.
+var ErrBlocked = errors.New("blocked response")
+
+// ErrIntercepted is the verdict on a TLS server with a forged certificate, it's a kind of ErrBlocked.
+var ErrIntercepted = fmt.Errorf("%w: TLS intercepted", ErrBlocked)
+
+// Inspector is a RightTran that judges the first response before it is forwarded to the client.
+// The data is held until it's decided. A bad verdict should wrap ErrBlocked.
+type Inspector interface {
+	Transformer
+	Verdict() (done bool, err error)
+}
@@ -0,0 +1,322 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package forwarder
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+)
+
+// The write chunk range of a shaped connection, so it sleeps briefly and often.
+const (
+	maxShapeChunk int = 16 * 1024
+	minShapeChunk int = 512
+)
+
+// The buckets of the clients and domains to keep before cleaning the idle and released ones up.
+const (
+	maxShapeBuckets = 1024
+	bucketIdle      = time.Minute
+)
+
+// Bucket is a token bucket of bytes per second, bursting up to 1 second of the rate.
+// It is shared by all the connections it applies to.
+type Bucket struct {
+	sync.Mutex
+	Rate   float64
+	tokens float64
+	last   time.Time
+	refs   int // the Shapers holding it, guarded by the Shaping
+}
+
+// NewBucket generates a new full Bucket.
+func NewBucket(rate float64) *Bucket {
+	return &Bucket{Rate: rate, tokens: rate, last: time.Now()}
+}
+
+// reserve takes n bytes of tokens, and returns the time to wait for them.
+func (b *Bucket) reserve(n int) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	b.tokens += b.Rate * now.Sub(b.last).Seconds()
+	if b.tokens > b.Rate {
+		b.tokens = b.Rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.Rate * float64(time.Second))
+}
+
+// Shaper limits the rates of a tunnel by the buckets of each direction.
+// Up is from the left (client) to the right (server), Down is the reverse.
+type Shaper struct {
+	Up   []*Bucket
+	Down []*Bucket
+	sh   *Shaping
+}
+
+// Release the buckets as the tunnel ends, so they can be cleaned up. A nil Shaper has none.
+func (s *Shaper) Release() {
+	if s == nil || s.sh == nil {
+		return
+	}
+	s.sh.mu.Lock()
+	for _, b := range s.Up {
+		b.refs--
+	}
+	for _, b := range s.Down {
+		b.refs--
+	}
+	s.sh.mu.Unlock()
+}
+
+// chunkSize is the write size of the buckets, about 1/4 second of the slowest one.
+func chunkSize(buckets []*Bucket) int {
+	n := maxShapeChunk
+	for _, b := range buckets {
+		if x := int(b.Rate / 4); x < n {
+			n = x
+		}
+	}
+	if n < minShapeChunk {
+		n = minShapeChunk
+	}
+	return n
+}
+
+// shapedWrite writes the data in chunks waiting for the buckets, the timeout applies to each chunk.
+func shapedWrite(c *bufconn.Conn, data []byte, timeout time.Duration, buckets []*Bucket) (err error) {
+	if len(buckets) == 0 {
+		_ = c.SetDeadline(time.Now().Add(timeout))
+		_, err = c.Write(data)
+		return
+	}
+	chunk := chunkSize(buckets)
+	for len(data) > 0 {
+		n := len(data)
+		if n > chunk {
+			n = chunk
+		}
+		var wait time.Duration
+		for _, b := range buckets {
+			if w := b.reserve(n); w > wait {
+				wait = w
+			}
+		}
+		time.Sleep(wait)
+		_ = c.SetDeadline(time.Now().Add(timeout))
+		_, err = c.Write(data[:n])
+		if err != nil {
+			return
+		}
+		data = data[n:]
+	}
+	return
+}
+
+// Limit is the up and down rates in bytes per second, 0 is unlimited.
+type Limit struct {
+	Up   float64
+	Down float64
+}
+
+// Shaping scopes.
+const (
+	ShapeAll    = "all"
+	ShapeClient = "client"
+	ShapeDirect = "direct"
+	ShapeProxy  = "proxy"
+	ShapeDomain = "domain"
+)
+
+// Shaping is the rules to generate a Shaper for a tunnel. The buckets are shared by the scopes:
+// All for all, Client for each client IP, Direct for all the direct ones, Proxy for each proxy,
+// Proxies for the specified proxy (Host:Port) overriding Proxy, Domains for the destinations by suffix.
+type Shaping struct {
+	All     *Limit
+	Client  *Limit
+	Direct  *Limit
+	Proxy   *Limit
+	Proxies map[string]*Limit
+	Domains map[string]*Limit
+	//local
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+}
+
+// parseRate parses a rate with an optional k/M/G (1024 based) suffix.
+func parseRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	m := 1.0
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			m = 1 << 10
+		case 'm', 'M':
+			m = 1 << 20
+		case 'g', 'G':
+			m = 1 << 30
+		}
+		if m > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, errors.New("invalid rate: " + s)
+	}
+	return v * m, nil
+}
+
+// ParseShaping parses the comma separated rules of Scope=Up/Down. Scope is one of
+// all, client, direct, proxy, proxy:Host:Port, domain:Suffix.
+func ParseShaping(s string) (*Shaping, error) {
+	sh := &Shaping{
+		Proxies: make(map[string]*Limit),
+		Domains: make(map[string]*Limit),
+		buckets: make(map[string]*Bucket),
+	}
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+		kv := strings.SplitN(r, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("invalid shaping rule: " + r)
+		}
+		rates := strings.SplitN(kv[1], "/", 2)
+		if len(rates) != 2 {
+			return nil, errors.New("invalid shaping rates: " + r)
+		}
+		up, err := parseRate(rates[0])
+		if err != nil {
+			return nil, err
+		}
+		down, err := parseRate(rates[1])
+		if err != nil {
+			return nil, err
+		}
+		l := &Limit{up, down}
+		scope, target := kv[0], ""
+		if i := strings.IndexByte(scope, ':'); i > 0 {
+			scope, target = scope[:i], scope[i+1:]
+		}
+		switch {
+		case scope == ShapeAll && target == "":
+			sh.All = l
+		case scope == ShapeClient && target == "":
+			sh.Client = l
+		case scope == ShapeDirect && target == "":
+			sh.Direct = l
+		case scope == ShapeProxy && target == "":
+			sh.Proxy = l
+		case scope == ShapeProxy:
+			sh.Proxies[target] = l
+		case scope == ShapeDomain && target != "":
+			sh.Domains[strings.ToLower(target)] = l
+		default:
+			return nil, errors.New("invalid shaping scope: " + kv[0])
+		}
+	}
+	return sh, nil
+}
+
+// idle tells if the Bucket isn't used for d, it's full by then.
+func (b *Bucket) idle(now time.Time, d time.Duration) bool {
+	b.Lock()
+	defer b.Unlock()
+	return now.Sub(b.last) > d
+}
+
+// cleanup the idle buckets, the ones held by a stalled tunnel are still shared.
+func (sh *Shaping) cleanup(now time.Time) {
+	for k, b := range sh.buckets {
+		if b.refs == 0 && b.idle(now, bucketIdle) {
+			delete(sh.buckets, k)
+		}
+	}
+}
+
+// add the buckets of a scope to the Shaper.
+func (sh *Shaping) add(s *Shaper, key string, l *Limit) {
+	if l == nil {
+		return
+	}
+	get := func(k string, rate float64) *Bucket {
+		b := sh.buckets[k]
+		if b == nil {
+			if len(sh.buckets) >= maxShapeBuckets {
+				sh.cleanup(time.Now())
+			}
+			b = NewBucket(rate)
+			sh.buckets[k] = b
+		}
+		b.refs++
+		return b
+	}
+	sh.mu.Lock()
+	if l.Up > 0 {
+		s.Up = append(s.Up, get(key+"/up", l.Up))
+	}
+	if l.Down > 0 {
+		s.Down = append(s.Down, get(key+"/down", l.Down))
+	}
+	sh.mu.Unlock()
+}
+
+// matchDomain finds the longest suffix rule of a host.
+func (sh *Shaping) matchDomain(host string) (string, *Limit) {
+	host = strings.ToLower(host)
+	var suffix string
+	var l *Limit
+	for k, v := range sh.Domains {
+		if (host == k || strings.HasSuffix(host, "."+k)) && len(k) > len(suffix) {
+			suffix, l = k, v
+		}
+	}
+	return suffix, l
+}
+
+// Shaper generates the Shaper for a tunnel of a client to a host, direct if proxy is empty.
+// It's nil if no rule applies, and a nil Shaping has no rule. It should be released as the tunnel ends.
+func (sh *Shaping) Shaper(client net.Addr, proxy, host string) *Shaper {
+	if sh == nil {
+		return nil
+	}
+	s := &Shaper{sh: sh}
+	sh.add(s, ShapeAll, sh.All)
+	if client != nil {
+		ip, _, err := net.SplitHostPort(client.String())
+		if err != nil {
+			ip = client.String()
+		}
+		sh.add(s, ShapeClient+":"+ip, sh.Client)
+	}
+	if proxy == "" {
+		sh.add(s, ShapeDirect, sh.Direct)
+	} else if l := sh.Proxies[proxy]; l != nil {
+		sh.add(s, ShapeProxy+":"+proxy, l)
+	} else {
+		sh.add(s, ShapeProxy+":"+proxy, sh.Proxy)
+	}
+	if suffix, l := sh.matchDomain(host); l != nil {
+		sh.add(s, ShapeDomain+":"+suffix, l)
+	}
+	if len(s.Up) == 0 && len(s.Down) == 0 {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package forwarder
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShaping(t *testing.T) {
+	sh, err := ParseShaping("all=0/8M,client=1M/2M,proxy=512k/1M,proxy:127.0.0.1:1080=0/0,domain:example.com=0/100k,domain:www.example.com=0/200k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &net.TCPAddr{IP: net.ParseIP("192.168.2.2"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("192.168.2.3"), Port: 1}
+
+	s := sh.Shaper(a, "", "www.example.com")
+	if len(s.Up) != 1 || len(s.Down) != 3 || s.Down[2].Rate != 200*1024 {
+		t.Fatalf("direct: %v up, %v down", len(s.Up), len(s.Down))
+	}
+	s2 := sh.Shaper(b, "127.0.0.2:1080", "example.org")
+	if len(s2.Up) != 2 || len(s2.Down) != 3 || s2.Down[0] != s.Down[0] || s2.Down[1] == s.Down[1] {
+		t.Fatal("the all bucket is shared, the client ones aren't")
+	}
+	s3 := sh.Shaper(b, "127.0.0.1:1080", "example.org")
+	if len(s3.Down) != 2 {
+		t.Fatal("the specified proxy overrides")
+	}
+	if sh = nil; sh.Shaper(a, "", "example.com") != nil {
+		t.Fail()
+	}
+	for _, r := range []string{"all=1M", "any=1M/1M", "client=x/1M", "domain:=1/1"} {
+		if _, err = ParseShaping(r); err == nil {
+			t.Errorf("%v: expected an error", r)
+		}
+	}
+}
+
+func TestShapingCleanup(t *testing.T) {
+	sh, _ := ParseShaping("all=1M/1M,client=1M/1M")
+	a := &net.TCPAddr{IP: net.ParseIP("192.168.2.2"), Port: 1}
+	// A stalled tunnel holds its buckets.
+	held := sh.Shaper(a, "", "example.com")
+	for i := 0; i < maxShapeBuckets; i++ {
+		sh.Shaper(&net.TCPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 1}, "", "example.com").Release()
+	}
+	if len(sh.buckets) != 2*(maxShapeBuckets+2) {
+		t.Fatalf("%v buckets", len(sh.buckets))
+	}
+	for _, b := range sh.buckets {
+		b.last = b.last.Add(-2 * bucketIdle)
+	}
+	s := sh.Shaper(&net.TCPAddr{IP: net.ParseIP("192.168.2.3"), Port: 1}, "", "example.com")
+	if len(sh.buckets) != 6 || s.Up[0] != held.Up[0] || s.Down[0] != held.Down[0] {
+		t.Fatalf("the idle released buckets should be cleaned up, the held ones shared: %v", len(sh.buckets))
+	}
+
+	// Released, the held ones go too.
+	held.Release()
+	s.Release()
+	for _, b := range sh.buckets {
+		b.last = b.last.Add(-2 * bucketIdle)
+	}
+	sh.cleanup(time.Now())
+	if len(sh.buckets) != 0 {
+		t.Fatalf("%v buckets", len(sh.buckets))
+	}
+}
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(100 * 1024)
+	if b.reserve(100*1024) != 0 {
+		t.Fatal("burst")
+	}
+	w := b.reserve(50 * 1024)
+	if w < 400*time.Millisecond || w > 600*time.Millisecond {
+		t.Fatalf("wait %v", w)
+	}
+	if chunkSize([]*Bucket{b}) != maxShapeChunk || chunkSize([]*Bucket{NewBucket(1024)}) != minShapeChunk {
+		t.Fail()
+	}
+}
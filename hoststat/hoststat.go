@@ -6,7 +6,6 @@
 package hoststat
 
 import (
-	"encoding/json"
 	"log"
 	"os"
 	"sync"
@@ -30,9 +29,10 @@ const EwmaSlide int = 10
 
 // HostStat is a single HostStat.
 type HostStat struct {
-	Value float64   `json:"v"`
-	Count int       `json:"n"`
-	Time  time.Time `json:"t"`
+	Value float64            `json:"v"`
+	Count int                `json:"n"`
+	Time  time.Time          `json:"t"`
+	IPs   map[string]*IPStat `json:"ips,omitempty"`
 	ewma  *ewma.EWMA
 }
 
@@ -43,6 +43,8 @@ type HostStats struct {
 	Validity       time.Duration
 	BackupInterval time.Duration
 	LastRecount    time.Time
+	Profile        string
+	profiles       map[string]map[string]*HostStat
 }
 
 // GetStat gets the HostStat.
@@ -81,30 +83,57 @@ func (hs *HostStats) Update(h string, v float64) {
 	hs.Unlock()
 }
 
+// Penalize a host as it fails steadily, such as it's intercepted, so it goes proxied until the stat recovers.
+func (hs *HostStats) Penalize(h string) {
+	hs.Lock()
+	stat := hs.Stats[h]
+	if stat == nil {
+		stat = &HostStat{}
+		hs.Stats[h] = stat
+	}
+	stat.ewma = ewma.NewMovingAverage(EwmaSlide)
+	stat.ewma.Set(0)
+	if stat.Count <= EwmaSlide {
+		stat.Count = EwmaSlide + 1
+	}
+	stat.Value = 0
+	stat.Time = time.Now()
+	hs.Unlock()
+}
+
 // Cleanup cleans expired stats up, and reset the stat periodically.
 func (hs *HostStats) Cleanup() {
 	if hs.Stats == nil {
 		return
 	}
 	hs.Lock()
-	stats := hs.Stats
+	hs.Stats = hs.cleanup(hs.Stats)
+	hs.cleanupProfiles()
+	hs.Unlock()
+}
+
+// cleanup cleans expired stats of a profile up, and reset the stat periodically.
+func (hs *HostStats) cleanup(stats map[string]*HostStat) map[string]*HostStat {
 	var changed bool
 	newStats := make(map[string]*HostStat)
 	for h, stat := range stats {
-		if hs.Validity > 0 && time.Since(stat.Time) > hs.Validity {
+		if hs.Validity > 0 && time.Since(stat.lastTime()) > hs.Validity {
 			changed = true
 		} else {
 			if hs.Validity > 0 && time.Since(hs.LastRecount) > hs.Validity && stat.Count > EwmaSlide {
 				stat.Count = EwmaSlide + 1
 				changed = true
 			}
+			if hs.Validity > 0 && stat.cleanupIPs(hs.Validity) {
+				changed = true
+			}
 			newStats[h] = stat
 		}
 	}
 	if changed {
-		hs.Stats = newStats
+		return newStats
 	}
-	hs.Unlock()
+	return stats
 }
 
 // Load HostStats from a file.
@@ -118,7 +147,7 @@ func (hs *HostStats) Load(file string) {
 	}
 
 	hs.Lock()
-	err = json.Unmarshal(data, &hs.Stats)
+	err = hs.unmarshal(data)
 	if err != nil {
 		log.Printf("[hoststats] %v", err)
 	}
@@ -131,7 +160,7 @@ func (hs *HostStats) Load(file string) {
 func (hs *HostStats) Save(file string) {
 	hs.Cleanup()
 	hs.RLock()
-	data, err := json.MarshalIndent(hs.Stats, "", "\t")
+	data, err := hs.marshal()
 	hs.RUnlock()
 	if err != nil {
 		log.Printf("[hoststats] %v", err)
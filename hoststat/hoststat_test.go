@@ -8,6 +8,7 @@ import (
 	"log"
 	"math"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -76,3 +77,135 @@ func TestHostStats(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestSuggest(t *testing.T) {
+	hs := &HostStats{Stats: make(map[string]*HostStat)}
+	for i := 0; i < 20; i++ {
+		hs.Update("www.blocked.com:443", 0)
+		hs.Update("img.blocked.com:443", 0)
+		hs.Update("www.good.co.uk:443", 1)
+		hs.Update("www.mixed.net:443", 0)
+		hs.Update("api.mixed.net:443", 1)
+		hs.Update("1.2.3.4:80", 0)
+		hs.Update(RouteKey("www.good.co.uk:443", "isp2"), 0)
+	}
+	hs.Update("rare.org:443", 0)
+
+	blocked, direct := hs.Suggest(20)
+	log.Printf("blocked: %v", blocked)
+	log.Printf("direct: %v", direct)
+	if len(blocked) != 2 || blocked[0].Domain != "1.2.3.4" || blocked[1].Domain != "blocked.com" || blocked[1].Hosts != 2 {
+		t.Fail()
+	}
+	if len(direct) != 1 || direct[0].Domain != "good.co.uk" {
+		t.Fail()
+	}
+
+	// The stats of all profiles, even none is chosen yet.
+	file := os.TempDir() + "/stat-suggest.json"
+	hs.SwitchProfile("home")
+	hs.SwitchProfile("office")
+	for i := 0; i < 20; i++ {
+		hs.Update("www.office.com:443", 1)
+	}
+	hs.Save(file)
+	hs = &HostStats{}
+	hs.Load(file)
+	os.Remove(file)
+	blocked, direct = hs.Suggest(20)
+	if len(blocked) != 2 || len(direct) != 2 || direct[1].Domain != "office.com" {
+		t.Fatalf("profiles: %v, %v", blocked, direct)
+	}
+}
+
+func TestRankIPs(t *testing.T) {
+	hs := &HostStats{Stats: make(map[string]*HostStat)}
+	h := "github.com:443"
+	hs.UpdateIP(h, "1.1.1.1", true, 80*time.Millisecond)
+	hs.UpdateIP(h, "2.2.2.2", true, 20*time.Millisecond)
+	hs.UpdateIP(h, "3.3.3.3", false, 0)
+	for i := 0; i < MaxIPFails; i++ {
+		hs.UpdateIP(h, "4.4.4.4", false, 0)
+	}
+
+	ranked := strings.Join(hs.RankIPs(h, []string{"4.4.4.4", "3.3.3.3", "5.5.5.5", "1.1.1.1", "2.2.2.2"}), ",")
+	log.Print(ranked)
+	if ranked != "2.2.2.2,1.1.1.1,5.5.5.5,3.3.3.3" {
+		t.Fail()
+	}
+	ranked = strings.Join(hs.RankIPs(h, []string{"4.4.4.4"}), ",")
+	if ranked != "4.4.4.4" {
+		t.Fail()
+	}
+
+	hs.UpdateIP(h, "4.4.4.4", true, time.Millisecond)
+	if hs.GetStat(h).IPs["4.4.4.4"].Fails != 0 {
+		t.Fail()
+	}
+}
+
+func TestRankRoutes(t *testing.T) {
+	hs := &HostStats{Stats: make(map[string]*HostStat)}
+	h := "github.com:443"
+	hs.Update(RouteKey(h, "isp1"), 0)
+	hs.Update(RouteKey(h, "isp2"), 1)
+	hs.Update(RouteKey(h, "isp3"), 1)
+	hs.Update(RouteKey(h, "isp3"), 0)
+	hs.Update(RouteKey(h, "isp3"), 1)
+
+	ranked := strings.Join(hs.RankRoutes(h, []string{"isp1", "isp4", "isp3", "isp2"}), ",")
+	log.Print(ranked)
+	if ranked != "isp2,isp3,isp4,isp1" {
+		t.Fail()
+	}
+	if !IsRouteKey(RouteKey("[::1]:443", "isp1")) || IsRouteKey("[::1]:443") {
+		t.Fail()
+	}
+}
+
+func TestSwitchProfile(t *testing.T) {
+	file := os.TempDir() + "/stat-profiles.json"
+	h := "github.com:443"
+	hs := &HostStats{Stats: make(map[string]*HostStat)}
+	hs.Update(h, 1)
+
+	hs.SwitchProfile("home")
+	if hs.GetStat(h).Count != 1 {
+		t.Fail()
+	}
+	hs.SwitchProfile("office")
+	if hs.GetStat(h).Count != 0 {
+		t.Fail()
+	}
+	hs.Update(h, 0)
+	hs.Update(h, 0)
+	hs.Save(file)
+
+	hs = &HostStats{Profile: "home"}
+	hs.Load(file)
+	if hs.GetStat(h).Count != 1 || hs.GetStat(h).Value != 1 {
+		t.Fail()
+	}
+	hs.SwitchProfile("office")
+	if hs.GetStat(h).Count != 2 || hs.GetStat(h).Value != 0 {
+		t.Fail()
+	}
+	os.Remove(file)
+}
+
+func TestPenalize(t *testing.T) {
+	hs := &HostStats{Stats: make(map[string]*HostStat)}
+	h := "github.com:443"
+	for i := 0; i < 5; i++ {
+		hs.Update(h, 1)
+	}
+	hs.Penalize(h)
+	stat := hs.GetStat(h)
+	if stat.Value != 0 || stat.Count != EwmaSlide+1 {
+		t.Fatalf("penalized: %+v", stat)
+	}
+	hs.Update(h, 1)
+	if v := hs.GetStat(h).Value; v <= 0 || v > 0.4 {
+		t.Fatalf("recovering: %v", v)
+	}
+}
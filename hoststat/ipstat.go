@@ -0,0 +1,188 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package hoststat
+
+import (
+	"sort"
+	"time"
+)
+
+/* HostStat with IPStats example:
+{
+	"github.com:443": {
+		"v": 0.7,
+		"n": 10,
+		"t": "2021-08-18T21:46:05.9266165+08:00",
+		"ips": {
+			"20.205.243.166": {
+				"v": 0.9,
+				"n": 8,
+				"f": 0,
+				"l": 52000000,
+				"t": "2021-08-18T21:46:05.9266165+08:00"
+			}
+		}
+	}
+}
+*/
+
+// IP stat limits.
+const (
+	// MaxIPFails is the consecutive failures to drop an IP.
+	MaxIPFails int = 3
+	// IPRetryAfter is the time a dropped IP gets a retry after.
+	IPRetryAfter = 30 * time.Minute
+	// maxIPStats is the max IPs recorded for a host, the oldest one goes first.
+	maxIPStats int = 16
+)
+
+// The EWMA weight of the latest value for IPStat.
+const ipAlpha float64 = 2 / float64(EwmaSlide+1)
+
+// IPStat is the connection quality of a resolved IP of a host.
+type IPStat struct {
+	Value   float64       `json:"v"`
+	Count   int           `json:"n"`
+	Fails   int           `json:"f"`
+	Latency time.Duration `json:"l"`
+	Time    time.Time     `json:"t"`
+}
+
+// good tells if the IP mostly succeeds.
+func (st *IPStat) good() bool {
+	return st.Value >= 0.5
+}
+
+// dropped tells if the IP fails in a row recently.
+func (st *IPStat) dropped() bool {
+	return st.Fails >= MaxIPFails && time.Since(st.Time) < IPRetryAfter
+}
+
+// UpdateIP updates the stat of an IP of a host, the latency counts only for a success.
+func (hs *HostStats) UpdateIP(h, ip string, ok bool, latency time.Duration) {
+	v := 0.0
+	if ok {
+		v = 1.0
+	}
+	hs.Lock()
+	if hs.Stats == nil {
+		hs.Stats = make(map[string]*HostStat)
+	}
+	stat := hs.Stats[h]
+	if stat == nil {
+		stat = &HostStat{}
+		hs.Stats[h] = stat
+	}
+	if stat.IPs == nil {
+		stat.IPs = make(map[string]*IPStat)
+	}
+	st := stat.IPs[ip]
+	if st == nil {
+		if len(stat.IPs) >= maxIPStats {
+			oldest := ""
+			for k, s := range stat.IPs {
+				if oldest == "" || s.Time.Before(stat.IPs[oldest].Time) {
+					oldest = k
+				}
+			}
+			delete(stat.IPs, oldest)
+		}
+		st = &IPStat{Value: v}
+		stat.IPs[ip] = st
+	} else {
+		st.Value += ipAlpha * (v - st.Value)
+	}
+	st.Count++
+	if ok {
+		st.Fails = 0
+		if st.Latency == 0 {
+			st.Latency = latency
+		} else {
+			st.Latency += time.Duration(ipAlpha * float64(latency-st.Latency))
+		}
+	} else {
+		st.Fails++
+	}
+	st.Time = time.Now()
+	hs.Unlock()
+}
+
+// RankIPs sorts the IPs of a host: the good ones by latency, then the unknown ones, then the poor ones.
+// The IPs failed MaxIPFails times in a row are dropped, unless all are.
+func (hs *HostStats) RankIPs(h string, IPs []string) []string {
+	const (
+		rankGood = iota
+		rankUnknown
+		rankPoor
+		rankDropped
+	)
+	type rankedIP struct {
+		ip      string
+		rank    int
+		latency time.Duration
+	}
+
+	ranked := make([]rankedIP, len(IPs))
+	hs.RLock()
+	var ipStats map[string]*IPStat
+	if stat := hs.Stats[h]; stat != nil {
+		ipStats = stat.IPs
+	}
+	for i, ip := range IPs {
+		r := rankedIP{ip, rankUnknown, 0}
+		if st := ipStats[ip]; st != nil {
+			switch {
+			case st.dropped():
+				r.rank = rankDropped
+			case st.good():
+				r.rank = rankGood
+				r.latency = st.Latency
+			default:
+				r.rank = rankPoor
+			}
+		}
+		ranked[i] = r
+	}
+	hs.RUnlock()
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank < ranked[j].rank
+		}
+		return ranked[i].latency < ranked[j].latency
+	})
+	sorted := make([]string, 0, len(ranked))
+	for _, r := range ranked {
+		if r.rank == rankDropped && ranked[0].rank != rankDropped {
+			break
+		}
+		sorted = append(sorted, r.ip)
+	}
+	return sorted
+}
+
+// lastTime is the time of the last update of the host or its IPs.
+// The ProxyStats are updated by the IPs only.
+func (stat *HostStat) lastTime() time.Time {
+	t := stat.Time
+	for _, st := range stat.IPs {
+		if st.Time.After(t) {
+			t = st.Time
+		}
+	}
+	return t
+}
+
+// cleanupIPs cleans the expired IPStats up.
+func (stat *HostStat) cleanupIPs(validity time.Duration) bool {
+	changed := false
+	for ip, st := range stat.IPs {
+		if time.Since(st.Time) > validity {
+			delete(stat.IPs, ip)
+			changed = true
+		}
+	}
+	return changed
+}
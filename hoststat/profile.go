@@ -0,0 +1,117 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package hoststat
+
+import (
+	"encoding/json"
+)
+
+/* HostStats with network profiles example:
+{
+	"profiles": {
+		"gateway:192.168.1.1/aa:bb:cc:dd:ee:ff": {
+			"github.com:443": {
+				"v": 0.7,
+				"n": 10,
+				"t": "2021-08-18T21:46:05.9266165+08:00"
+			}
+		}
+	}
+}
+*/
+
+// profiledStats is the file format with network profiles.
+type profiledStats struct {
+	Profiles map[string]map[string]*HostStat `json:"profiles"`
+}
+
+// SwitchProfile switches to the independent stats of a network profile.
+// The stats without profile are adopted by the first profile.
+func (hs *HostStats) SwitchProfile(p string) {
+	hs.Lock()
+	if p != hs.Profile {
+		if hs.profiles == nil {
+			hs.profiles = make(map[string]map[string]*HostStat)
+		}
+		stats := hs.profiles[p]
+		if stats == nil {
+			if hs.Profile == "" {
+				stats = hs.Stats
+			}
+			if stats == nil {
+				stats = make(map[string]*HostStat)
+			}
+			hs.profiles[p] = stats
+		}
+		hs.Stats = stats
+		hs.Profile = p
+	}
+	hs.Unlock()
+}
+
+// allStats are the stats of the current profile, and of the others.
+func (hs *HostStats) allStats() []map[string]*HostStat {
+	all := []map[string]*HostStat{hs.Stats}
+	for p, stats := range hs.profiles {
+		if p != hs.Profile {
+			all = append(all, stats)
+		}
+	}
+	return all
+}
+
+// cleanupProfiles cleans up the stats of the inactive profiles, and drops the empty ones.
+func (hs *HostStats) cleanupProfiles() {
+	for p, stats := range hs.profiles {
+		if p == hs.Profile {
+			hs.profiles[p] = hs.Stats
+			continue
+		}
+		stats = hs.cleanup(stats)
+		if len(stats) == 0 {
+			delete(hs.profiles, p)
+		} else {
+			hs.profiles[p] = stats
+		}
+	}
+}
+
+// unmarshal the stats with or without network profiles.
+func (hs *HostStats) unmarshal(data []byte) error {
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	if _, ok := raw["profiles"]; !ok {
+		return json.Unmarshal(data, &hs.Stats)
+	}
+
+	var ps profiledStats
+	err = json.Unmarshal(data, &ps)
+	if err != nil {
+		return err
+	}
+	hs.profiles = ps.Profiles
+	if hs.profiles == nil {
+		hs.profiles = make(map[string]map[string]*HostStat)
+	}
+	hs.Stats = hs.profiles[hs.Profile]
+	if hs.Stats == nil {
+		hs.Stats = make(map[string]*HostStat)
+		if hs.Profile != "" {
+			hs.profiles[hs.Profile] = hs.Stats
+		}
+	}
+	return nil
+}
+
+// marshal the stats, with network profiles if ever switched.
+func (hs *HostStats) marshal() ([]byte, error) {
+	if hs.profiles == nil {
+		return json.MarshalIndent(hs.Stats, "", "\t")
+	}
+	return json.MarshalIndent(&profiledStats{hs.profiles}, "", "\t")
+}
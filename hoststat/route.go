@@ -0,0 +1,76 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package hoststat
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+/* HostStat of a direct route example:
+{
+	"github.com:443@isp2": {
+		"v": 0.9,
+		"n": 12,
+		"t": "2021-08-18T21:46:05.9266165+08:00"
+	}
+}
+*/
+
+// RouteKey is the stat key of a host by a direct route, the IPs are stat per route too,
+// as an uplink of an ISP may reach the server where the others don't.
+func RouteKey(h, route string) string {
+	return h + "@" + route
+}
+
+// IsRouteKey tells if a stat key is of a direct route.
+func IsRouteKey(h string) bool {
+	return strings.LastIndexByte(h, '@') > strings.LastIndexByte(h, ':')
+}
+
+// RankRoutes sorts the direct routes of a host: the good ones by value, then the unknown ones, then the poor ones.
+// The routes of the same rank keep the given order.
+func (hs *HostStats) RankRoutes(h string, routes []string) []string {
+	const (
+		rankGood = iota
+		rankUnknown
+		rankPoor
+	)
+	type rankedRoute struct {
+		route string
+		rank  int
+		value float64
+	}
+
+	ranked := make([]rankedRoute, len(routes))
+	hs.RLock()
+	for i, route := range routes {
+		r := rankedRoute{route, rankUnknown, 0}
+		stat := hs.Stats[RouteKey(h, route)]
+		if stat != nil && stat.Count > 0 && (hs.Validity <= 0 || time.Since(stat.Time) <= hs.Validity) {
+			r.value = stat.Value
+			if stat.Value >= 0.5 {
+				r.rank = rankGood
+			} else {
+				r.rank = rankPoor
+			}
+		}
+		ranked[i] = r
+	}
+	hs.RUnlock()
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank < ranked[j].rank
+		}
+		return ranked[i].value > ranked[j].value
+	})
+	sorted := make([]string, len(ranked))
+	for i, r := range ranked {
+		sorted[i] = r.route
+	}
+	return sorted
+}
@@ -0,0 +1,98 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package hoststat
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+// The values a domain is suggested to be blocked (<=) or direct (>=).
+const (
+	SuggestBlockedValue = 0.05
+	SuggestDirectValue  = 0.95
+)
+
+// Suggestion is a registrable domain (or IP) with the aggregated stat of its hosts.
+type Suggestion struct {
+	Domain string
+	Value  float64
+	Count  int
+	Hosts  int
+}
+
+// HostOf strips the port from a stat key.
+func HostOf(h string) string {
+	i := strings.LastIndexByte(h, ':')
+	if i > 0 {
+		h = h[:i]
+	}
+	return strings.Trim(h, "[]")
+}
+
+// Suggest aggregates the stats by registrable domains, picks out the domains whose hosts all
+// persistently fail (blocked) or all persistently succeed (direct), with at least minCount visits.
+// The stats of all the network profiles count, as the learned domains apply to all.
+func (hs *HostStats) Suggest(minCount int) (blocked, direct []Suggestion) {
+	type aggregation struct {
+		Suggestion
+		bad  bool
+		good bool
+	}
+	domains := make(map[string]*aggregation)
+
+	hs.RLock()
+	for _, stats := range hs.allStats() {
+		for h, stat := range stats {
+			// The routes are counted by their hosts already.
+			if IsRouteKey(h) || hs.Validity > 0 && time.Since(stat.Time) > hs.Validity {
+				continue
+			}
+			d := statichost.RegistrableDomain(HostOf(h))
+			a := domains[d]
+			if a == nil {
+				a = &aggregation{Suggestion: Suggestion{Domain: d}, bad: true, good: true}
+				domains[d] = a
+			}
+			a.Value += stat.Value
+			a.Count += stat.Count
+			a.Hosts++
+			a.bad = a.bad && stat.Value <= SuggestBlockedValue
+			a.good = a.good && stat.Value >= SuggestDirectValue
+		}
+	}
+	hs.RUnlock()
+
+	for _, a := range domains {
+		if a.Count < minCount {
+			continue
+		}
+		a.Value /= float64(a.Hosts)
+		if a.bad {
+			blocked = append(blocked, a.Suggestion)
+		} else if a.good {
+			direct = append(direct, a.Suggestion)
+		}
+	}
+	sort.Slice(blocked, func(i, j int) bool {
+		return blocked[i].Domain < blocked[j].Domain
+	})
+	sort.Slice(direct, func(i, j int) bool {
+		return direct[i].Domain < direct[j].Domain
+	})
+	return
+}
+
+// Domains lists the domains of Suggestions.
+func Domains(ss []Suggestion) []string {
+	var ds []string
+	for _, s := range ss {
+		ds = append(ds, s.Domain)
+	}
+	return ds
+}
@@ -0,0 +1,49 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/statichost"
+)
+
+// Suggest prints the domains learned from the stat file.
+func Suggest(config *Config) {
+	hs := &hoststat.HostStats{Validity: config.StatValidity}
+	hs.Load(config.StatFile)
+	blocked, direct := hs.Suggest(config.LearnMinCount)
+	printSuggestions("blocked", blocked)
+	printSuggestions("direct", direct)
+}
+
+func printSuggestions(kind string, ss []hoststat.Suggestion) {
+	fmt.Printf("# learned-%v: %v\n", kind, len(ss))
+	for _, s := range ss {
+		fmt.Printf("%v\t# v: %.2f, n: %v, hosts: %v\n", s.Domain, s.Value, s.Count, s.Hosts)
+	}
+}
+
+// Learn promotes the suggested domains into the learned files.
+func Learn(config *Config, hs *hoststat.HostStats) {
+	blocked, direct := hs.Suggest(config.LearnMinCount)
+	bd, dd := hoststat.Domains(blocked), hoststat.Domains(direct)
+
+	lb := statichost.LearnedHosts{}
+	lb.Load(config.LearnedBlocked)
+	lb.Demote(dd)
+	lb.Promote(bd, config.LearnValidity)
+	lb.Save(config.LearnedBlocked)
+
+	ld := statichost.LearnedHosts{}
+	ld.Load(config.LearnedDirect)
+	ld.Demote(bd)
+	ld.Promote(dd, config.LearnValidity)
+	ld.Save(config.LearnedDirect)
+
+	log.Printf("[learn] blocked: %v/%v, direct: %v/%v", len(bd), len(lb), len(dd), len(ld))
+}
@@ -8,10 +8,19 @@ package main
 import (
 	"log"
 	"sync"
+	"time"
 
+	"github.com/lifenjoiner/pd/connectivity"
 	"github.com/lifenjoiner/pd/dispatcher"
+	"github.com/lifenjoiner/pd/forwarder"
 	"github.com/lifenjoiner/pd/hoststat"
+	"github.com/lifenjoiner/pd/netprofile"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol"
+	"github.com/lifenjoiner/pd/protocol/http"
 	"github.com/lifenjoiner/pd/proxypool"
+	"github.com/lifenjoiner/pd/resolver"
+	"github.com/lifenjoiner/pd/server"
 	"github.com/lifenjoiner/pd/server/tcp"
 	"github.com/lifenjoiner/pd/statichost"
 )
@@ -25,17 +34,167 @@ var (
 // ServeFromConfig starts the serving.
 func ServeFromConfig(config *Config) {
 	svrConf := &config.SvrConf
-	dispatcher.GlobalStaticHosts = statichost.MapStaticFiles(config.Blocked, config.Direct)
-	dispatcher.GlobalHostStats = hoststat.MapStatsFile(config.StatFile, config.StatValidity)
-	dispatcher.StartProbeDirect(config.NetProbeURL, svrConf.UpstreamTimeout)
+	if len(config.Allow) > 0 || len(config.Deny) > 0 {
+		acl, err := server.ParseACL(config.Allow, config.Deny)
+		if err != nil {
+			log.Fatalf("[server] ACL: %v", err)
+		}
+		svrConf.ACL = acl
+	}
+	if l := &config.Limiter; l.MaxConns > 0 || l.MaxClientConns > 0 || l.Rate > 0 || l.ClientRate > 0 {
+		svrConf.Limiter = l
+	}
+	e := dispatcher.NewEngine()
+	// The proxies don't follow -directbind.
+	e.ProxyDialer = network.SystemDialer
+	for _, b := range []struct {
+		bind   string
+		dialer *network.Dialer
+	}{{config.DirectBind, &e.Dialer}, {config.ProxyBind, &e.ProxyDialer}} {
+		bd, err := network.ParseBind(b.bind)
+		if err != nil {
+			log.Fatalf("[network] %v", err)
+		}
+		if bd != nil {
+			*b.dialer = bd
+		}
+	}
+	if len(config.DirectRoutes) > 0 {
+		routes, err := dispatcher.ParseRoutes(config.DirectRoutes)
+		if err != nil {
+			log.Fatalf("[dispatcher] %v", err)
+		}
+		e.Routes = routes
+	}
+	e.HostStats = hoststat.MapStatsFile(config.StatFile, config.StatValidity)
+	if len(config.ProxyStatFile) > 0 {
+		e.ProxyStats = hoststat.MapStatsFile(config.ProxyStatFile, config.StatValidity)
+	}
+	if config.LearnAuto {
+		Learn(config, e.HostStats)
+	}
+	var w *netprofile.Watcher
+	if len(config.NetProfile) > 0 {
+		w = &netprofile.Watcher{
+			Method:   config.NetProfile,
+			Timeout:  svrConf.UpstreamTimeout,
+			OnChange: e.HostStats.SwitchProfile,
+		}
+		w.Start()
+	}
+	e.StaticHosts = statichost.MapStaticFiles(config.Blocked, config.Direct)
+	e.LearnedHosts = statichost.StaticHosts{}
+	e.LearnedHosts.Learn(config.LearnedDirect, statichost.StaticDirect)
+	e.LearnedHosts.Learn(config.LearnedBlocked, statichost.StaticBlocked)
+	if len(config.TLSRules) > 0 {
+		e.TLSRules.Load(config.TLSRules)
+	}
+	if len(config.Signatures) > 0 {
+		e.Signatures = &http.Signatures{}
+		e.Signatures.Load(config.Signatures)
+	}
+	if len(config.TLSRoots) > 0 || len(config.TLSPins) > 0 {
+		cv, err := protocol.NewCertVerifier(config.TLSRoots, config.TLSPins)
+		if err != nil {
+			log.Fatalf("[protocol] %v", err)
+		}
+		e.CertVerifier = cv
+	}
+	switch config.Strategy {
+	case "default":
+	case "threshold":
+		s, err := dispatcher.ParseThresholdStrategy(config.Thresholds, config.Backoffs, config.DirectTries, config.ProxyTries)
+		if err != nil {
+			log.Fatalf("[dispatcher] %v", err)
+		}
+		e.Strategy = s
+	default:
+		log.Fatalf("[dispatcher] unknown strategy: %v", config.Strategy)
+	}
+	if len(config.Bandwidth) > 0 {
+		sh, err := forwarder.ParseShaping(config.Bandwidth)
+		if err != nil {
+			log.Fatalf("[forwarder] %v", err)
+		}
+		e.Shaping = sh
+	}
+	order, err := dispatcher.ParseUpstreamOrder(config.UpstreamOrder)
+	if err != nil {
+		log.Fatalf("[dispatcher] %v", err)
+	}
+	e.UpstreamOrder = order
+	if config.Affinity > 0 {
+		a, err := dispatcher.NewAffinity(config.Affinity, config.AffinityScope)
+		if err != nil {
+			log.Fatalf("[dispatcher] %v", err)
+		}
+		e.Affinity = a
+	}
+	if config.BreakerFails > 0 {
+		e.Breaker = dispatcher.NewBreaker(config.BreakerFails, config.BreakerWindow, config.BreakerCool)
+	}
+	e.Resolvers = make(map[statichost.Strategy]*resolver.Resolver)
+	for rule, dns := range map[statichost.Strategy]struct {
+		name      string
+		upstreams string
+	}{
+		statichost.StaticNil:     {"dns", config.DNS},
+		statichost.StaticDirect:  {"dnsdirect", config.DNSDirect},
+		statichost.StaticBlocked: {"dnsblocked", config.DNSBlocked},
+	} {
+		if len(dns.upstreams) == 0 && rule != statichost.StaticNil {
+			continue
+		}
+		r, err := resolver.New(dns.upstreams, svrConf.UpstreamTimeout)
+		if err != nil {
+			log.Printf("[resolver] %v", err)
+			continue
+		}
+		r.Dialer = e.Dialer
+		r.ProxyDialer = e.ProxyDialer
+		// An answer of one DNS shouldn't be served for the hosts of another.
+		if config.DNSCacheTTL > 0 {
+			r.Cache = resolver.NewCache(config.DNSCacheTTL, config.DNSNegativeTTL)
+			r.Cache.Name = dns.name
+			r.Cache.StartCleanup(10 * time.Minute)
+		}
+		e.Resolvers[rule] = r
+	}
+	m, err := connectivity.New(config.NetProbeURL, svrConf.UpstreamTimeout, config.NetProbeQuorum)
+	if err == nil {
+		for _, ck := range m.Checkers {
+			ck.Dialer = e.Dialer
+		}
+		m.Subscribe(func(online bool) {
+			if !online {
+				return
+			}
+			// Back online, maybe on another network.
+			if w != nil {
+				go w.Check()
+			}
+			for _, pp := range e.ProxyPools() {
+				go pp.Update()
+			}
+		})
+		e.Connectivity = m
+		m.Start()
+	} else {
+		log.Printf("[connectivity] %v, always act as online!", err)
+	}
 	go func() {
-		dispatcher.GlobalProxyPool = proxypool.InitProxyPool(svrConf.Proxies, svrConf.ProxyProbeURL, svrConf.UpstreamTimeout)
+		e.SetProxyPool(proxypool.InitProxyPool(svrConf.Proxies, svrConf.ProxyProbeURL, svrConf.UpstreamTimeout, e.ProxyDialer))
 	}()
 
 	var wg sync.WaitGroup
 	for _, listen := range config.Listens {
 		wg.Add(1)
-		s := &tcp.Server{WG: &wg, Addr: listen, Config: svrConf}
+		s := &tcp.Server{WG: &wg, Addr: listen, Config: svrConf, Engine: e}
+		go s.ListenAndServe()
+	}
+	for _, listen := range config.Transparents {
+		wg.Add(1)
+		s := &tcp.Server{WG: &wg, Addr: listen, Transparent: true, Config: svrConf, Engine: e}
 		go s.ListenAndServe()
 	}
 	wg.Wait()
@@ -43,6 +202,10 @@ func ServeFromConfig(config *Config) {
 
 func main() {
 	cfg := parseConfig()
+	if cfg.Command == "suggest" {
+		Suggest(cfg)
+		return
+	}
 	log.Printf("%v v%v - %v", name, version, description)
 	ServeFromConfig(cfg)
 }
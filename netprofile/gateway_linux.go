@@ -0,0 +1,66 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package netprofile
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// The numbers in /proc/net/route are in the host byte order.
+var littleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// gateway fingerprints by the IPv4 default gateway, and its MAC if known.
+func gateway() (string, error) {
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return "", err
+	}
+	var gw string
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 3 || f[1] != "00000000" || f[2] == "00000000" {
+			continue
+		}
+		b, err := hex.DecodeString(f[2])
+		if err != nil || len(b) != 4 {
+			continue
+		}
+		IP := net.IP(b)
+		if littleEndian {
+			IP = make(net.IP, 4)
+			binary.LittleEndian.PutUint32(IP, binary.BigEndian.Uint32(b))
+		}
+		gw = IP.String()
+		break
+	}
+	if gw == "" {
+		return "", errors.New("netprofile: no default gateway")
+	}
+
+	fp := "gateway:" + gw
+	data, err = os.ReadFile("/proc/net/arp")
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			f := strings.Fields(line)
+			if len(f) >= 4 && f[0] == gw {
+				fp += "/" + f[3]
+				break
+			}
+		}
+	}
+	return fp, nil
+}
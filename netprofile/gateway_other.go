@@ -0,0 +1,17 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package netprofile
+
+import (
+	"errors"
+)
+
+// gateway isn't supported, falls back to the local address.
+func gateway() (string, error) {
+	return "", errors.New("netprofile: gateway is only supported on Linux")
+}
@@ -0,0 +1,123 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package netprofile fingerprints the network we are on.
+package netprofile
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fingerprinting methods. Others are taken as a probing URL, whose content identifies the network.
+const (
+	MethodGateway = "gateway"
+	MethodLocal   = "local"
+)
+
+// The interval to check if the network changes.
+const checkInterval = 30 * time.Second
+
+// Fingerprint the network by the method.
+func Fingerprint(method string, timeout time.Duration) (string, error) {
+	switch method {
+	case MethodGateway:
+		fp, err := gateway()
+		if err == nil {
+			return fp, nil
+		}
+		return localAddr()
+	case MethodLocal:
+		return localAddr()
+	}
+	return probe(method, timeout)
+}
+
+// localAddr fingerprints by the local address routed to the Internet, masked as the network.
+// Dialing UDP sends nothing.
+func localAddr() (string, error) {
+	c, err := net.Dial("udp", "8.8.8.8:53")
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+	IP := c.LocalAddr().(*net.UDPAddr).IP
+	mask := net.CIDRMask(64, 128)
+	if IP.To4() != nil {
+		IP = IP.To4()
+		mask = net.CIDRMask(24, 32)
+	}
+	return "local:" + IP.Mask(mask).String(), nil
+}
+
+// probe fingerprints by the content of a URL, like the status page of the router.
+func probe(u string, timeout time.Duration) (string, error) {
+	if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+		return "", errors.New("netprofile: unknown method: " + u)
+	}
+	client := &http.Client{Timeout: timeout}
+	res, err := client.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	h := sha1.New()
+	_, _ = io.WriteString(h, res.Status)
+	_, _ = io.Copy(h, io.LimitReader(res.Body, 4096))
+	return "probe:" + hex.EncodeToString(h.Sum(nil))[:12], nil
+}
+
+// Watcher watches the network changes.
+type Watcher struct {
+	sync.Mutex
+	Method   string
+	Timeout  time.Duration
+	OnChange func(profile string)
+	profile  string
+}
+
+// Profile is the current one.
+func (w *Watcher) Profile() string {
+	w.Lock()
+	defer w.Unlock()
+	return w.profile
+}
+
+// Check fingerprints the network, and calls OnChange if it changes.
+func (w *Watcher) Check() {
+	fp, err := Fingerprint(w.Method, w.Timeout)
+	if err != nil {
+		log.Printf("[netprofile] %v", err)
+		return
+	}
+	w.Lock()
+	changed := fp != w.profile
+	w.profile = fp
+	w.Unlock()
+	if changed {
+		log.Printf("[netprofile] We are on: %v", fp)
+		if w.OnChange != nil {
+			w.OnChange(fp)
+		}
+	}
+}
+
+// Start checks the network now and periodically.
+func (w *Watcher) Start() {
+	w.Check()
+	go func() {
+		for {
+			time.Sleep(checkInterval)
+			w.Check()
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// BoundDialer dials from a local source address and/or a network interface, such as an uplink of a multi-WAN router.
+// The source address is chosen by the family of the destination, a hostname dials the families having one only.
+type BoundDialer struct {
+	Interface string
+	IPv4      net.IP
+	IPv6      net.IP
+}
+
+// ParseBind parses the comma separated source IPs (one per family) and interface name into a BoundDialer.
+// An empty one is nil.
+func ParseBind(s string) (*BoundDialer, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b := &BoundDialer{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		ip := net.ParseIP(item)
+		switch {
+		case ip == nil:
+			if b.Interface != "" {
+				return nil, fmt.Errorf("more than one interface: %v", s)
+			}
+			b.Interface = item
+		case ip.To4() != nil:
+			if b.IPv4 != nil {
+				return nil, fmt.Errorf("more than one IPv4: %v", s)
+			}
+			b.IPv4 = ip
+		default:
+			if b.IPv6 != nil {
+				return nil, fmt.Errorf("more than one IPv6: %v", s)
+			}
+			b.IPv6 = ip
+		}
+	}
+	if b.Interface != "" && !canBindToDevice {
+		return nil, fmt.Errorf("binding to interface %v isn't supported on this OS", b.Interface)
+	}
+	return b, nil
+}
+
+// localIP chooses the source IP for the destination address of the network.
+// It's nil for a hostname that may go either family, if both are bound.
+func (b *BoundDialer) localIP(network, address string) net.IP {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && ip.To4() != nil, strings.HasSuffix(network, "4"), b.IPv6 == nil:
+		return b.IPv4
+	case ip != nil, strings.HasSuffix(network, "6"), b.IPv4 == nil:
+		return b.IPv6
+	}
+	return nil
+}
+
+// DialContext dials the address from the bound source.
+// A hostname is resolved first if both families are bound, so each IP is dialed from its family.
+func (b *BoundDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	ip := b.localIP(network, address)
+	if host, port, err := net.SplitHostPort(address); err == nil && ip == nil && b.IPv4 != nil && b.IPv6 != nil {
+		ips, err := SystemResolver.LookupHost(ctx, host)
+		if err == nil && len(ips) == 0 {
+			err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			var c net.Conn
+			c, err = b.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return c, nil
+			}
+		}
+		return nil, err
+	}
+	d := &net.Dialer{}
+	if ip != nil {
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+		if strings.HasPrefix(network, "udp") {
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		}
+	}
+	if b.Interface != "" {
+		d.Control = bindToDevice(b.Interface)
+	}
+	return d.DialContext(ctx, network, address)
+}
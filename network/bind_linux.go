@@ -0,0 +1,28 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package network
+
+import (
+	"syscall"
+)
+
+const canBindToDevice = true
+
+// bindToDevice binds the socket to the interface by SO_BINDTODEVICE, it requires CAP_NET_RAW.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var err error
+		cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		})
+		if cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package network
+
+import (
+	"syscall"
+)
+
+const canBindToDevice = false
+
+// bindToDevice isn't supported.
+func bindToDevice(string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
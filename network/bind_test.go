@@ -0,0 +1,55 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBoundDialer(t *testing.T) {
+	if b, err := ParseBind(""); b != nil || err != nil {
+		t.Fatal("empty is nil")
+	}
+	if _, err := ParseBind("192.0.2.1,192.0.2.2"); err == nil {
+		t.Fatal("more than one IPv4")
+	}
+	b, err := ParseBind("127.0.0.2,::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.localIP("tcp", "[2001:db8::1]:443").Equal(net.ParseIP("::1")) || b.localIP("tcp", "example.com:443") != nil ||
+		!b.localIP("tcp4", "example.com:443").Equal(net.ParseIP("127.0.0.2")) {
+		t.Fatal("choose by the family")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	// A hostname is resolved to dial by the families.
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	for _, addr := range []string{l.Addr().String(), "localhost:" + port} {
+		c, err := DialTimeout(b, "tcp", addr, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := c.LocalAddr().(*net.TCPAddr).IP; !ip.Equal(net.ParseIP("127.0.0.2")) {
+			t.Fatalf("%v source: %v", addr, ip)
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package network
+
+import (
+	"context"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Behavior is how a fake endpoint acts on dialing.
+type Behavior int
+
+// Fake endpoint behaviors.
+const (
+	// Accept connects to the Handler.
+	Accept Behavior = iota
+	// Refuse fails the dial at once.
+	Refuse
+	// Blackhole hangs the dial until timeout.
+	Blackhole
+	// Reset connects and takes the writes, then resets on reading.
+	Reset
+)
+
+// Endpoint is a fake server address.
+type Endpoint struct {
+	Behavior Behavior
+	Delay    time.Duration  // before the dial succeeds or fails
+	Handler  func(net.Conn) // serves the server side of an accepted connection
+}
+
+// Fake is an in-memory network. It resolves hosts by Hosts, and dials "IP:Port" by Endpoints.
+// An address without Endpoint refuses. It counts the dials for checking.
+type Fake struct {
+	sync.Mutex
+	Hosts     map[string][]string
+	Endpoints map[string]*Endpoint
+	dials     map[string]int
+}
+
+// NewFake generates an empty Fake network.
+func NewFake() *Fake {
+	return &Fake{
+		Hosts:     make(map[string][]string),
+		Endpoints: make(map[string]*Endpoint),
+		dials:     make(map[string]int),
+	}
+}
+
+// Handle adds an endpoint.
+func (f *Fake) Handle(address string, b Behavior, handler func(net.Conn)) *Endpoint {
+	ep := &Endpoint{Behavior: b, Handler: handler}
+	f.Lock()
+	f.Endpoints[address] = ep
+	f.Unlock()
+	return ep
+}
+
+// Dials tells the times an address is dialed.
+func (f *Fake) Dials(address string) int {
+	f.Lock()
+	defer f.Unlock()
+	return f.dials[address]
+}
+
+// LookupHost looks up a host by Hosts.
+func (f *Fake) LookupHost(_ context.Context, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+	f.Lock()
+	addrs := f.Hosts[host]
+	f.Unlock()
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return append([]string(nil), addrs...), nil
+}
+
+// fakeTimeout is a net.Error of timeout.
+type fakeTimeout struct{}
+
+func (fakeTimeout) Error() string   { return "i/o timeout" }
+func (fakeTimeout) Timeout() bool   { return true }
+func (fakeTimeout) Temporary() bool { return true }
+
+// DialContext dials an address by its Endpoint.
+func (f *Fake) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	f.Lock()
+	f.dials[address]++
+	ep := f.Endpoints[address]
+	f.Unlock()
+	opErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: network, Addr: fakeAddr(address), Err: err}
+	}
+
+	if ep == nil {
+		return nil, opErr(syscall.ECONNREFUSED)
+	}
+	wait := ep.Delay
+	if ep.Behavior == Blackhole {
+		wait = -1
+	}
+	if wait != 0 {
+		var t <-chan time.Time
+		if wait > 0 {
+			t = time.After(wait)
+		}
+		select {
+		case <-t:
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, opErr(fakeTimeout{})
+			}
+			return nil, opErr(ctx.Err())
+		}
+	}
+
+	switch ep.Behavior {
+	case Refuse:
+		return nil, opErr(syscall.ECONNREFUSED)
+	case Reset:
+		c, s := net.Pipe()
+		s.Close()
+		return &resetConn{fakeConn{c, address}}, nil
+	}
+	c, s := net.Pipe()
+	if ep.Handler != nil {
+		go func() {
+			ep.Handler(&fakeConn{s, "fake:0"})
+			s.Close()
+		}()
+	} else {
+		s.Close()
+	}
+	return &fakeConn{c, address}, nil
+}
+
+// fakeAddr is a fake net.Addr.
+type fakeAddr string
+
+func (a fakeAddr) Network() string { return "tcp" }
+func (a fakeAddr) String() string  { return string(a) }
+
+// fakeConn is a pipe with the remote address.
+type fakeConn struct {
+	net.Conn
+	remote string
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr { return fakeAddr(c.remote) }
+func (c *fakeConn) LocalAddr() net.Addr  { return fakeAddr("fake:0") }
+
+// resetConn resets on reading.
+type resetConn struct {
+	fakeConn
+}
+
+func (c *resetConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (c *resetConn) Read([]byte) (int, error) {
+	return 0, &net.OpError{Op: "read", Net: "tcp", Addr: c.RemoteAddr(), Err: syscall.ECONNRESET}
+}
@@ -0,0 +1,38 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package network abstracts dialing and resolving, so the traffic can go through a custom stack or a fake network.
+package network
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Dialer dials up connections, `*net.Dialer` is one.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Resolver looks up hosts, `*net.Resolver` is one.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// The system network.
+var (
+	SystemDialer   Dialer   = &net.Dialer{}
+	SystemResolver Resolver = net.DefaultResolver
+)
+
+// DialTimeout dials the address with timeout by the dialer, a nil one is the SystemDialer.
+func DialTimeout(d Dialer, network, address string, timeout time.Duration) (net.Conn, error) {
+	if d == nil {
+		d = SystemDialer
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.DialContext(ctx, network, address)
+}
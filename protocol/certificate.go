@@ -0,0 +1,147 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lifenjoiner/pd/forwarder"
+)
+
+// The server flight to hold at most for the certificates.
+const maxServerFlightSize = 64 * 1024
+
+/* Pins example:
+# Host (suffix) and the SHA-256 of a SubjectPublicKeyInfo in the chain, in hex.
+example.com 5c8ff6...
+*/
+
+// CertVerifier checks the server certificates of TLS 1.2 by the trust store and the pins.
+// A pinned host must present a pinned public key, the others are verified by the Roots, if VerifyChain.
+type CertVerifier struct {
+	VerifyChain bool
+	Roots       *x509.CertPool // nil is the system one
+	Pins        map[string][]string
+}
+
+// NewCertVerifier generates a CertVerifier. roots is "system" or a PEM file, "" doesn't verify the chains.
+func NewCertVerifier(roots, pins string) (*CertVerifier, error) {
+	cv := &CertVerifier{Pins: make(map[string][]string)}
+	if roots != "" {
+		cv.VerifyChain = true
+		if roots != "system" {
+			data, err := os.ReadFile(roots)
+			if err != nil {
+				return nil, err
+			}
+			cv.Roots = x509.NewCertPool()
+			if !cv.Roots.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificate in %v", roots)
+			}
+		}
+	}
+	if pins != "" {
+		data, err := os.ReadFile(pins)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			f := strings.Fields(line)
+			if len(f) == 0 || f[0][0] == '#' {
+				continue
+			}
+			if len(f) < 2 {
+				return nil, fmt.Errorf("bad pin: %q", line)
+			}
+			cv.Pins[strings.ToLower(f[0])] = append(cv.Pins[strings.ToLower(f[0])], strings.ToLower(f[1]))
+		}
+	}
+	return cv, nil
+}
+
+// pins gets the pins of a host. Right to left, match sufix after the separator.
+func (cv *CertVerifier) pins(host string) []string {
+	h := "." + strings.ToLower(host)
+	for i := 0; i < len(h); i++ {
+		if h[i] != '.' {
+			continue
+		}
+		if pins := cv.Pins[h[i+1:]]; pins != nil {
+			return pins
+		}
+	}
+	return nil
+}
+
+// Verify the DER certificate chain for the host, an empty host skips the name check.
+// Only an unknown authority, a wrong name or a pin mismatch is taken as interception,
+// as an expired or misused certificate is the server's fault.
+func (cv *CertVerifier) Verify(host string, chain [][]byte) error {
+	certs := make([]*x509.Certificate, 0, len(chain))
+	for _, der := range chain {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil
+	}
+	if pins := cv.pins(host); pins != nil {
+		for _, c := range certs {
+			sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if hex.EncodeToString(sum[:]) == pin {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: no pinned key for %v", forwarder.ErrIntercepted, host)
+	}
+	if !cv.VerifyChain {
+		return nil
+	}
+	opts := x509.VerifyOptions{DNSName: host, Roots: cv.Roots, Intermediates: x509.NewCertPool()}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	_, err := certs[0].Verify(opts)
+	var ua x509.UnknownAuthorityError
+	var hn x509.HostnameError
+	if errors.As(err, &ua) || errors.As(err, &hn) {
+		return fmt.Errorf("%w: %v", forwarder.ErrIntercepted, err)
+	}
+	return nil
+}
+
+// CertInspector holds the first flight of a direct TLS server, and verifies the certificates in it.
+type CertInspector struct {
+	Host     string
+	Verifier *CertVerifier
+	//local
+	buf []byte
+}
+
+// Transform records the server data without changing it.
+func (ci *CertInspector) Transform(b []byte) []byte {
+	ci.buf = append(ci.buf, b...)
+	return nil
+}
+
+// Verdict verifies the certificates when they are got.
+func (ci *CertInspector) Verdict() (bool, error) {
+	certs, done := ParseServerCertificates(ci.buf)
+	if !done {
+		return len(ci.buf) >= maxServerFlightSize, nil
+	}
+	return true, ci.Verifier.Verify(ci.Host, certs)
+}
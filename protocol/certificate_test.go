@@ -0,0 +1,137 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/forwarder"
+)
+
+// newCert generates a certificate for the hosts, signed by the parent or self-signed.
+func newCert(t *testing.T, hosts []string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "pd test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     hosts,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer = parent.Leaf
+		signerKey = parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// serverFlight captures the first flight of a TLS server.
+func serverFlight(t *testing.T, cert tls.Certificate, maxVersion uint16) []byte {
+	hello := clientHello(t, &tls.Config{ServerName: "www.example.com"})
+	c, s := net.Pipe()
+	go func() {
+		_ = s.SetDeadline(time.Now().Add(time.Second))
+		_ = tls.Server(s, &tls.Config{Certificates: []tls.Certificate{cert}, MaxVersion: maxVersion}).Handshake()
+	}()
+	_ = c.SetDeadline(time.Now().Add(time.Second))
+	if _, err := c.Write(hello); err != nil {
+		t.Fatal(err)
+	}
+	var flight []byte
+	b := make([]byte, 4096)
+	for {
+		n, err := c.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flight = append(flight, b[:n]...)
+		if _, done := ParseServerCertificates(flight); done {
+			break
+		}
+	}
+	c.Close()
+	return flight
+}
+
+func TestCertVerifier(t *testing.T) {
+	ca := newCert(t, nil, nil)
+	leaf := newCert(t, []string{"www.example.com"}, &ca)
+	leaf.Certificate = append(leaf.Certificate, ca.Certificate[0])
+
+	flight := serverFlight(t, leaf, tls.VersionTLS12)
+	certs, done := ParseServerCertificates(flight)
+	if !done || len(certs) != 2 {
+		t.Fatalf("certificates: %v", len(certs))
+	}
+	if _, done = ParseServerCertificates(flight[:20]); done {
+		t.Fatal("incomplete flight")
+	}
+	if tls13, done := ParseServerCertificates(serverFlight(t, leaf, tls.VersionTLS13)); !done || tls13 != nil {
+		t.Fatal("TLS 1.3 certificates are encrypted")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	cv := &CertVerifier{VerifyChain: true, Roots: roots}
+	if err := cv.Verify("www.example.com", certs); err != nil {
+		t.Fatal(err)
+	}
+	if err := cv.Verify("www.example.org", certs); !errors.Is(err, forwarder.ErrIntercepted) {
+		t.Fatalf("wrong name: %v", err)
+	}
+	cv.Roots = x509.NewCertPool()
+	if err := cv.Verify("www.example.com", certs); !errors.Is(err, forwarder.ErrIntercepted) {
+		t.Fatalf("unknown authority: %v", err)
+	}
+
+	sum := sha256.Sum256(ca.Leaf.RawSubjectPublicKeyInfo)
+	cv = &CertVerifier{Pins: map[string][]string{"example.com": {hex.EncodeToString(sum[:])}}}
+	if err := cv.Verify("www.example.com", certs); err != nil {
+		t.Fatal(err)
+	}
+	cv.Pins["example.com"] = []string{"00"}
+	if err := cv.Verify("www.example.com", certs); !errors.Is(err, forwarder.ErrIntercepted) {
+		t.Fatalf("pin mismatch: %v", err)
+	}
+	if err := cv.Verify("example.net", certs); err != nil {
+		t.Fatalf("not pinned: %v", err)
+	}
+
+	ci := &CertInspector{Host: "www.example.com", Verifier: cv}
+	ci.Transform(flight[:20])
+	if done, _ := ci.Verdict(); done {
+		t.Fatal("should wait for the certificates")
+	}
+	ci.Transform(flight[20:])
+	if done, err := ci.Verdict(); !done || !errors.Is(err, forwarder.ErrBlocked) {
+		t.Fatalf("verdict: %v, %v", done, err)
+	}
+}
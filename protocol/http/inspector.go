@@ -0,0 +1,148 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/lifenjoiner/pd/forwarder"
+)
+
+// The response head and body to inspect at most.
+const maxInspectSize = 8 * 1024
+
+/* Signatures example:
+# Text in the response head or body start.
+text Access to this site is blocked
+# Redirects to the hosts (suffix).
+location block.example.net
+*/
+
+// Signatures of the block pages.
+type Signatures struct {
+	Texts     []string
+	Locations []string
+}
+
+// Load Signatures from a file.
+func (s *Signatures) Load(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Printf("[http] %v: %v", file, err)
+	}
+	s.Upsert(string(data))
+}
+
+// Upsert appends the Signatures by line(s).
+func (s *Signatures) Upsert(in string) {
+	for _, line := range strings.Split(in, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		kv := strings.SplitN(line, " ", 2)
+		if len(kv) < 2 {
+			log.Printf("[http] bad signature: %q", line)
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "text":
+			s.Texts = append(s.Texts, v)
+		case "location":
+			s.Locations = append(s.Locations, strings.ToLower(v))
+		default:
+			log.Printf("[http] bad signature: %q", line)
+		}
+	}
+}
+
+// ResponseInspector recognizes the injected block pages and redirects of a direct plain HTTP response.
+// A redirect to an IP host is suspicious by itself, as a censor would do.
+type ResponseInspector struct {
+	Host       string
+	Signatures *Signatures
+	//local
+	buf []byte
+}
+
+// Transform records the response data without changing it.
+func (ri *ResponseInspector) Transform(b []byte) []byte {
+	if n := maxInspectSize - len(ri.buf); n > 0 {
+		if len(b) > n {
+			b = b[:n]
+		}
+		ri.buf = append(ri.buf, b...)
+	}
+	return nil
+}
+
+// Verdict judges the response when the whole head is got.
+func (ri *ResponseInspector) Verdict() (bool, error) {
+	i := bytes.Index(ri.buf, []byte("\r\n\r\n"))
+	if i < 0 && len(ri.buf) < maxInspectSize {
+		return false, nil
+	}
+	blocked := func(reason string) (bool, error) {
+		return true, fmt.Errorf("%w: %v", forwarder.ErrBlocked, reason)
+	}
+
+	if ri.Signatures != nil {
+		for _, t := range ri.Signatures.Texts {
+			if bytes.Contains(ri.buf, []byte(t)) {
+				return blocked("signature " + t)
+			}
+		}
+	}
+	tpr := textproto.NewReader(bufio.NewReader(bytes.NewReader(ri.buf)))
+	line, err := tpr.ReadLine()
+	if err != nil {
+		return true, nil
+	}
+	proto, status, _, ok := parseStartLine(line)
+	if !ok || !strings.HasPrefix(proto, "HTTP/") || len(status) != 3 || status[0] != '3' {
+		return true, nil
+	}
+	header, _ := tpr.ReadMIMEHeader()
+	loc, err := url.Parse(header.Get("Location"))
+	if err != nil || loc.Host == "" {
+		return true, nil
+	}
+	host := strings.ToLower(loc.Hostname())
+	if net.ParseIP(host) != nil && host != ri.Host {
+		return blocked("redirect to " + host)
+	}
+	if ri.Signatures != nil {
+		for _, l := range ri.Signatures.Locations {
+			if host == l || strings.HasSuffix(host, "."+l) {
+				return blocked("redirect to " + host)
+			}
+		}
+	}
+	return true, nil
+}
+
+// Replayable tells if the request is idempotent and can be resent as a whole.
+// The origin may have acted on a POST or PATCH already, it's never resent.
+func (r *Request) Replayable() bool {
+	if r.Header.Get("Transfer-Encoding") != "" {
+		return false
+	}
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "DELETE":
+		return len(r.PostData) == 0
+	case "PUT":
+		return r.Header.Get("Content-Length") == fmt.Sprint(len(r.PostData))
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package http
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/lifenjoiner/pd/forwarder"
+)
+
+func TestResponseInspector(t *testing.T) {
+	signatures := &Signatures{}
+	signatures.Upsert("# comment\ntext Access Denied by Censor\nlocation Block.Example.net\nbad")
+	if len(signatures.Texts) != 1 || len(signatures.Locations) != 1 || signatures.Locations[0] != "block.example.net" {
+		t.Fatalf("signatures: %+v", signatures)
+	}
+
+	tests := []struct {
+		name    string
+		resp    []string
+		done    bool
+		blocked bool
+	}{
+		{"partial head", []string{"HTTP/1.1 200 OK\r\n"}, false, false},
+		{"ok", []string{"HTTP/1.1 200 OK\r\n", "Content-Length: 2\r\n\r\nok"}, true, false},
+		{"signature", []string{"HTTP/1.1 403 Forbidden\r\n\r\n<html>Access Denied by Censor</html>"}, true, true},
+		{"redirect to IP", []string{"HTTP/1.1 302 Found\r\nLocation: http://10.10.34.34/?type=blocked\r\n\r\n"}, true, true},
+		{"redirect to itself", []string{"HTTP/1.1 302 Found\r\nLocation: http://192.0.2.1:8080/\r\n\r\n"}, true, false},
+		{"redirect to domain", []string{"HTTP/1.1 301 Moved\r\nLocation: https://moved.example.com/\r\n\r\n"}, true, false},
+		{"redirect to location", []string{"HTTP/1.1 302 Found\r\nLocation: http://www.block.example.net/\r\n\r\n"}, true, true},
+		{"relative redirect", []string{"HTTP/1.1 302 Found\r\nLocation: /login\r\n\r\n"}, true, false},
+		{"not HTTP", []string{"SSH-2.0-OpenSSH\r\n\r\n"}, true, false},
+		{"head too large", []string{"HTTP/1.1 200 OK\r\nX: " + strings.Repeat("x", maxInspectSize)}, true, false},
+	}
+	for _, tt := range tests {
+		ri := &ResponseInspector{Host: "192.0.2.1", Signatures: signatures}
+		for _, b := range tt.resp {
+			if ri.Transform([]byte(b)) != nil {
+				t.Errorf("%v: the data shouldn't change", tt.name)
+			}
+		}
+		done, err := ri.Verdict()
+		if done != tt.done || (err != nil) != tt.blocked || err != nil && !errors.Is(err, forwarder.ErrBlocked) {
+			t.Errorf("%v: %v %v", tt.name, done, err)
+		}
+	}
+}
+
+func TestRequestReplayable(t *testing.T) {
+	tests := []struct {
+		method     string
+		header     map[string]string
+		data       string
+		replayable bool
+	}{
+		{"GET", nil, "", true},
+		{"HEAD", nil, "", true},
+		{"DELETE", nil, "", true},
+		{"GET", nil, "data", false},
+		{"CONNECT", nil, "", false},
+		{"POST", map[string]string{"Content-Length": "4"}, "data", false},
+		{"POST", nil, "", false},
+		{"PATCH", map[string]string{"Content-Length": "4"}, "data", false},
+		{"PUT", map[string]string{"Content-Length": "4"}, "data", true},
+		{"PUT", map[string]string{"Content-Length": "8"}, "data", false},
+		{"PUT", map[string]string{"Content-Length": "0"}, "", true},
+		{"PUT", map[string]string{"Transfer-Encoding": "chunked"}, "", false},
+	}
+	for _, tt := range tests {
+		r := &Request{Method: tt.method, Header: textproto.MIMEHeader{}, PostData: []byte(tt.data)}
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		if r.Replayable() != tt.replayable {
+			t.Errorf("%v %v %q: should be %v", tt.method, tt.header, tt.data, tt.replayable)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"net/textproto"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/lifenjoiner/pd/bufconn"
@@ -71,44 +72,62 @@ func (r *Request) GetRequest(w io.Writer, rd *bufio.Reader) (err error) {
 	return
 }
 
-// Request to a upstream server.
-func (r *Request) Request(fw *forwarder.Forwarder, proxy, seg bool) (restart bool, err error) {
-	_ = fw.LeftConn.SetDeadline(time.Now().Add(2 * fw.Timeout))
-	_ = fw.RightConn.SetDeadline(time.Now().Add(fw.Timeout))
+// Payload is the first packet of the client got by GetRequest, for CONNECT.
+func (r *Request) Payload() []byte {
+	return r.TLSData
+}
+
+// Send the request to a upstream server.
+func (r *Request) Send(c *bufconn.Conn, proxy, seg bool) (err error) {
 	if r.Method == "CONNECT" {
-		if len(r.TLSData) > 0 {
-			if seg {
-				h := []byte(r.URL.Hostname())
-				i := bytes.Index(r.TLSData, h)
-				i += len(h) / 2
-				_, err = fw.RightConn.SplitWrite(r.TLSData, i)
-			} else {
-				_, err = fw.RightConn.Write(r.TLSData)
-			}
-		} else {
-			// drop it
-			return false, nil
-		}
-	} else {
 		if seg {
-			err = r.writeRequest(fw.RightConn, proxy)
+			i := protocol.SplitIndex(r.TLSData, r.URL.Hostname())
+			_, err = c.SplitWrite(r.TLSData, i)
 		} else {
-			bw := &bytes.Buffer{}
-			err = r.writeRequest(bw, proxy)
-			if err == nil {
-				_, err = fw.RightConn.Write(bw.Bytes())
-			}
+			_, err = c.Write(r.TLSData)
 		}
-		if err == nil && len(r.PostData) > 0 {
-			_, err = fw.RightConn.Write(r.PostData)
+		return
+	}
+	if seg {
+		err = r.writeRequest(c, proxy)
+	} else {
+		bw := &bytes.Buffer{}
+		err = r.writeRequest(bw, proxy)
+		if err == nil {
+			_, err = c.Write(bw.Bytes())
 		}
 	}
+	if err == nil && len(r.PostData) > 0 {
+		_, err = c.Write(r.PostData)
+	}
+	return
+}
+
+// Request to a upstream server.
+func (r *Request) Request(fw *forwarder.Forwarder, proxy, seg bool) (restart bool, err error) {
+	_ = fw.LeftConn.SetDeadline(time.Now().Add(2 * fw.Timeout))
+	_ = fw.RightConn.SetDeadline(time.Now().Add(fw.Timeout))
+	if r.Method == "CONNECT" && len(r.TLSData) == 0 {
+		// drop it
+		return false, nil
+	}
+	err = r.Send(fw.RightConn, proxy, seg)
 	if err == nil {
 		restart, err = fw.Tunnel()
 	}
 	return
 }
 
+// Reject the request with "503 Service Unavailable", if it isn't responsed.
+func (r *Request) Reject(w io.Writer, reason string) (err error) {
+	if !r.Responsed {
+		_, err = w.Write([]byte("HTTP/1.1 503 Service Unavailable\r\nContent-Type: text/plain\r\nContent-Length: " +
+			strconv.Itoa(len(reason)) + "\r\nConnection: close\r\n\r\n" + reason))
+		r.Responsed = true
+	}
+	return
+}
+
 func (r *Request) cacheTLSData(rd *bufio.Reader) (err error) {
 	r.TLSData, err = bufconn.ReceiveData(rd)
 	return
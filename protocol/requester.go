@@ -9,6 +9,7 @@ import (
 	"bufio"
 	"io"
 
+	"github.com/lifenjoiner/pd/bufconn"
 	"github.com/lifenjoiner/pd/forwarder"
 )
 
@@ -20,5 +21,8 @@ type Requester interface {
 	Hostname() string
 	Port() string
 	GetRequest(w io.Writer, r *bufio.Reader) error
+	Payload() []byte
+	Send(c *bufconn.Conn, proxy, seg bool) error
 	Request(fw *forwarder.Forwarder, proxy, seg bool) (restart bool, err error)
+	Reject(w io.Writer, reason string) error
 }
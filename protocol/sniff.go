@@ -0,0 +1,72 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"strings"
+)
+
+// ServerName gets the SNI from a TLS ClientHello record, or "".
+func ServerName(b []byte) string {
+	h, err := ParseClientHello(b)
+	if err != nil {
+		return ""
+	}
+	return h.ServerName
+}
+
+// HTTPHost gets the hostname of the `Host` header from a plain HTTP request, or "".
+func HTTPHost(b []byte) string {
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		b = b[:i]
+	}
+	lines := strings.Split(string(b), "\r\n")
+	if len(lines) < 2 || !strings.Contains(lines[0], " HTTP/") {
+		return ""
+	}
+	for _, line := range lines[1:] {
+		if len(line) > 5 && strings.EqualFold(line[:5], "host:") {
+			host := strings.TrimSpace(line[5:])
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return host
+		}
+	}
+	return ""
+}
+
+// SniffHost gets the hostname from the first packet of a client, by TLS SNI or HTTP `Host`.
+// It is "" if not found, or it's an IP.
+func SniffHost(b []byte) string {
+	host := ServerName(b)
+	if host == "" {
+		host = HTTPHost(b)
+	}
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	return host
+}
+
+// SplitIndex is the index in the middle of the host in the data, or of the sniffed one if the host isn't there.
+// The SNI is located by parsing the ClientHello.
+func SplitIndex(b []byte, host string) int {
+	if h, err := ParseClientHello(b); err == nil && h.ServerName != "" {
+		return h.serverNameOffset + len(h.ServerName)/2
+	}
+	i := bytes.Index(b, []byte(host))
+	if i < 0 {
+		if host = SniffHost(b); host != "" {
+			i = bytes.Index(b, []byte(host))
+		}
+	}
+	if i < 0 {
+		return len(b) / 2
+	}
+	return i + len(host)/2
+}
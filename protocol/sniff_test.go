@@ -0,0 +1,55 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+// clientHello captures the ClientHello of a TLS client.
+func clientHello(t *testing.T, config *tls.Config) []byte {
+	c, s := net.Pipe()
+	go func() {
+		_ = c.SetDeadline(time.Now().Add(time.Second))
+		_ = tls.Client(c, config).Handshake()
+	}()
+	b := make([]byte, 4096)
+	n, err := s.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	return b[:n]
+}
+
+func TestSniffHost(t *testing.T) {
+	hello := clientHello(t, &tls.Config{ServerName: "www.example.com"})
+	if host := SniffHost(hello); host != "www.example.com" {
+		t.Fatalf("SNI: %q", host)
+	}
+	if i := SplitIndex(hello, "93.184.216.34"); string(hello[i-7:i+8]) != "www.example.com" {
+		t.Fatal("split at the sniffed host")
+	}
+	if host := SniffHost(clientHello(t, &tls.Config{InsecureSkipVerify: true})); host != "" {
+		t.Fatalf("no SNI: %q", host)
+	}
+	if host := SniffHost(hello[:60]); host != "" {
+		t.Fatalf("truncated: %q", host)
+	}
+
+	req := []byte("GET / HTTP/1.1\r\nUser-Agent: test\r\nhost: example.org:8080\r\n\r\n")
+	if host := SniffHost(req); host != "example.org" {
+		t.Fatalf("Host: %q", host)
+	}
+	if host := SniffHost([]byte("GET / HTTP/1.1\r\nHost: 1.2.3.4\r\n\r\n")); host != "" {
+		t.Fatalf("IP Host: %q", host)
+	}
+	if host := SniffHost([]byte("\x05\x01\x00")); host != "" {
+		t.Fatalf("unknown: %q", host)
+	}
+}
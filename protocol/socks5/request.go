@@ -6,13 +6,13 @@ package socks5
 
 import (
 	"bufio"
-	"bytes"
 	"errors"
 	"io"
 	"time"
 
 	"github.com/lifenjoiner/pd/bufconn"
 	"github.com/lifenjoiner/pd/forwarder"
+	"github.com/lifenjoiner/pd/protocol"
 	"github.com/lifenjoiner/pd/protocol/socks"
 )
 
@@ -74,23 +74,42 @@ func (r *Request) GetRequest(w io.Writer, rd *bufio.Reader) (err error) {
 	return
 }
 
+// Payload is the first packet of the client got by GetRequest.
+func (r *Request) Payload() []byte {
+	return r.RequestData
+}
+
+// Send the request to a upstream server.
+func (r *Request) Send(c *bufconn.Conn, _, seg bool) (err error) {
+	if seg {
+		i := protocol.SplitIndex(r.RequestData, r.DestHost)
+		_, err = c.SplitWrite(r.RequestData, i)
+	} else {
+		_, err = c.Write(r.RequestData)
+	}
+	return
+}
+
 // Request to a upstream server.
 func (r *Request) Request(fw *forwarder.Forwarder, _, seg bool) (restart bool, err error) {
 	_ = fw.LeftConn.SetDeadline(time.Now().Add(2 * fw.Timeout))
 	_ = fw.RightConn.SetDeadline(time.Now().Add(fw.Timeout))
-	if seg {
-		i := bytes.Index(r.RequestData, []byte(r.DestHost))
-		i += len(r.DestHost) / 2
-		_, err = fw.RightConn.SplitWrite(r.RequestData, i)
-	} else {
-		_, err = fw.RightConn.Write(r.RequestData)
-	}
+	err = r.Send(fw.RightConn, false, seg)
 	if err == nil {
 		restart, err = fw.Tunnel()
 	}
 	return
 }
 
+// Reject the request with "Host unreachable", if it isn't responsed.
+func (r *Request) Reject(w io.Writer, _ string) (err error) {
+	if !r.Responsed {
+		_, err = w.Write([]byte{5, 4, 0, 1, 0, 0, 0, 0, 0, 0})
+		r.Responsed = true
+	}
+	return
+}
+
 // ParseRequest parses a request.
 func ParseRequest(rd *bufio.Reader) (req *Request, err error) {
 	var p socks.Packet
@@ -0,0 +1,264 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TLS extension types.
+const (
+	ExtServerName        uint16 = 0
+	ExtALPN              uint16 = 16
+	ExtSupportedVersions uint16 = 43
+)
+
+// ErrNotClientHello is returned if the data isn't a complete TLS ClientHello.
+var ErrNotClientHello = errors.New("not a TLS ClientHello")
+
+// ClientHello is the parsed fields of a TLS ClientHello.
+type ClientHello struct {
+	Version           uint16 // legacy_version
+	SupportedVersions []uint16
+	ServerName        string
+	ALPN              []string
+	Extensions        []uint16
+	// serverNameOffset is the offset of ServerName in the data.
+	serverNameOffset int
+}
+
+// reader reads a ClientHello with bounds checking.
+type reader struct {
+	b   []byte
+	off int
+	err bool
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err || n < 0 || len(r.b)-r.off < n {
+		r.err = true
+		return nil
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *reader) uint(n int) int {
+	v := 0
+	for _, c := range r.bytes(n) {
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// vector reads a length prefixed vector.
+func (r *reader) vector(n int) *reader {
+	l := r.uint(n)
+	start := r.off
+	r.bytes(l)
+	if r.err {
+		return &reader{err: true}
+	}
+	return &reader{b: r.b[:start+l], off: start}
+}
+
+// truncated reads a length prefixed vector, that may be truncated at the end of the data.
+func (r *reader) truncated(n int) *reader {
+	l := r.uint(n)
+	if r.err {
+		return &reader{err: true}
+	}
+	if rest := len(r.b) - r.off; l > rest {
+		l = rest
+	}
+	start := r.off
+	r.off += l
+	return &reader{b: r.b[:start+l], off: start}
+}
+
+// ParseClientHello parses a TLS ClientHello from the first record of the client.
+// The ClientHello can be larger than the first record or read, the extensions after the data are missing then.
+func ParseClientHello(b []byte) (*ClientHello, error) {
+	r := &reader{b: b}
+	// record header: type(1) version(2) length(2), handshake header: type(1) length(3)
+	if r.uint(1) != 0x16 || r.uint(1) != 0x03 {
+		return nil, ErrNotClientHello
+	}
+	r.bytes(3)
+	if r.uint(1) != 0x01 {
+		return nil, ErrNotClientHello
+	}
+	body := r.truncated(3)
+
+	h := &ClientHello{Version: uint16(body.uint(2))}
+	body.bytes(32) // random
+	body.vector(1) // session id
+	body.vector(2) // cipher suites
+	body.vector(1) // compression methods
+	if body.err {
+		return nil, ErrNotClientHello
+	}
+	if body.off == len(body.b) {
+		// No extension.
+		return h, nil
+	}
+	exts := body.truncated(2)
+	for !exts.err && exts.off < len(exts.b) {
+		typ := uint16(exts.uint(2))
+		ext := exts.vector(2)
+		if exts.err {
+			// truncated
+			break
+		}
+		h.Extensions = append(h.Extensions, typ)
+		switch typ {
+		case ExtServerName:
+			list := ext.vector(2)
+			for !list.err && list.off < len(list.b) {
+				nameType := list.uint(1)
+				name := list.vector(2)
+				if nameType == 0 && !name.err {
+					h.serverNameOffset = name.off
+					h.ServerName = string(name.bytes(len(name.b) - name.off))
+					break
+				}
+			}
+		case ExtALPN:
+			list := ext.vector(2)
+			for !list.err && list.off < len(list.b) {
+				p := list.vector(1)
+				if !p.err {
+					h.ALPN = append(h.ALPN, string(p.bytes(len(p.b)-p.off)))
+				}
+			}
+		case ExtSupportedVersions:
+			list := ext.vector(1)
+			for !list.err && list.off < len(list.b) {
+				v := list.uint(2)
+				if !list.err {
+					h.SupportedVersions = append(h.SupportedVersions, uint16(v))
+				}
+			}
+		}
+	}
+	return h, nil
+}
+
+// MaxVersion is the highest version the client supports, ignoring the GREASE values.
+func (h *ClientHello) MaxVersion() uint16 {
+	v := h.Version
+	for _, sv := range h.SupportedVersions {
+		if sv&0x0f0f != 0x0a0a && sv > v {
+			v = sv
+		}
+	}
+	return v
+}
+
+// HasALPN tells if the client offers the protocol.
+func (h *ClientHello) HasALPN(proto string) bool {
+	for _, p := range h.ALPN {
+		if p == proto {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSVersionName names a TLS version.
+func TLSVersionName(v uint16) string {
+	switch v {
+	case 0x0300:
+		return "SSL3.0"
+	case 0x0301:
+		return "TLS1.0"
+	case 0x0302:
+		return "TLS1.1"
+	case 0x0303:
+		return "TLS1.2"
+	case 0x0304:
+		return "TLS1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// String formats the ClientHello for logging.
+func (h *ClientHello) String() string {
+	exts := make([]string, len(h.Extensions))
+	for i, e := range h.Extensions {
+		exts[i] = fmt.Sprint(e)
+	}
+	return fmt.Sprintf("%v sni:%q alpn:%v exts:[%v]", TLSVersionName(h.MaxVersion()), h.ServerName,
+		strings.Join(h.ALPN, ","), strings.Join(exts, ","))
+}
+
+// ParseServerCertificates gets the certificate chain from the first flight of a TLS 1.2 server.
+// done tells if the data is enough to decide. The certificates of TLS 1.3 are encrypted, so they are nil.
+func ParseServerCertificates(b []byte) (certs [][]byte, done bool) {
+	var hs []byte
+	r := &reader{b: b}
+	for r.off < len(r.b) {
+		typ := r.uint(1)
+		r.bytes(2)
+		record := r.vector(2)
+		if r.err {
+			// incomplete
+			break
+		}
+		if typ != 0x16 {
+			// ChangeCipherSpec: resumed. Or not TLS.
+			return nil, true
+		}
+		hs = append(hs, record.b[record.off:]...)
+
+		m := &reader{b: hs}
+		for m.off < len(m.b) {
+			msgType := m.uint(1)
+			msg := m.vector(3)
+			if m.err {
+				// continued in the next record
+				break
+			}
+			switch msgType {
+			case 0x02:
+				if serverHelloVersion(msg) == 0x0304 {
+					return nil, true
+				}
+			case 0x0b:
+				list := msg.vector(3)
+				for !list.err && list.off < len(list.b) {
+					cert := list.vector(3)
+					if !list.err {
+						certs = append(certs, cert.b[cert.off:])
+					}
+				}
+				return certs, true
+			default:
+				return nil, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// serverHelloVersion gets the selected version of a ServerHello message.
+func serverHelloVersion(msg *reader) uint16 {
+	v := uint16(msg.uint(2))
+	msg.bytes(32) // random
+	msg.vector(1) // session id
+	msg.bytes(3)  // cipher suite, compression method
+	exts := msg.vector(2)
+	for !exts.err && exts.off < len(exts.b) {
+		typ := uint16(exts.uint(2))
+		ext := exts.vector(2)
+		if typ == ExtSupportedVersions && !ext.err {
+			v = uint16(ext.uint(2))
+		}
+	}
+	return v
+}
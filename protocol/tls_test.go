@@ -0,0 +1,48 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package protocol
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestParseClientHello(t *testing.T) {
+	b := clientHello(t, &tls.Config{ServerName: "www.example.com", NextProtos: []string{"h2", "http/1.1"}})
+	h, err := ParseClientHello(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(h)
+	if h.ServerName != "www.example.com" || !h.HasALPN("h2") || !h.HasALPN("http/1.1") || h.HasALPN("h3") {
+		t.Fatalf("SNI/ALPN: %v", h)
+	}
+	if h.MaxVersion() != 0x0304 || TLSVersionName(h.MaxVersion()) != "TLS1.3" {
+		t.Fatalf("version: %v", h)
+	}
+	exts := map[uint16]bool{}
+	for _, e := range h.Extensions {
+		exts[e] = true
+	}
+	if !exts[ExtServerName] || !exts[ExtALPN] || !exts[ExtSupportedVersions] {
+		t.Fatalf("extensions: %v", h.Extensions)
+	}
+	if i := SplitIndex(b, "www.example.com"); string(b[i-7:i+8]) != "www.example.com" {
+		t.Fatal("split at the SNI")
+	}
+
+	h, err = ParseClientHello(clientHello(t, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12}))
+	if err != nil || h.ServerName != "" || len(h.ALPN) != 0 || h.MaxVersion() != 0x0303 {
+		t.Fatalf("no SNI: %v %v", h, err)
+	}
+
+	// The extensions are truncated after the SNI.
+	if h, err = ParseClientHello(b[:len(b)-4]); err != nil || h.ServerName != "www.example.com" {
+		t.Fatalf("truncated: %v %v", h, err)
+	}
+	if _, err = ParseClientHello([]byte("GET / HTTP/1.1\r\n\r\n")); err != ErrNotClientHello {
+		t.Fatal("not a ClientHello")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package transparent offers the request of a redirected connection, which has no handshake.
+package transparent
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/forwarder"
+	"github.com/lifenjoiner/pd/protocol"
+)
+
+// Request is the original destination of a redirected connection, and its first packet.
+// It's tunneled as a CONNECT, so the hostname can be sniffed from the packet.
+type Request struct {
+	DestHost    string
+	DestPort    string
+	RequestData []byte
+}
+
+// Command requested by.
+func (r *Request) Command() string {
+	return "CONNECT"
+}
+
+// Target URL requested to.
+func (r *Request) Target() string {
+	return net.JoinHostPort(r.DestHost, r.DestPort)
+}
+
+// Host requested to.
+func (r *Request) Host() string {
+	return net.JoinHostPort(r.DestHost, r.DestPort)
+}
+
+// Hostname only requested to.
+func (r *Request) Hostname() string {
+	return r.DestHost
+}
+
+// Port requested to.
+func (r *Request) Port() string {
+	return r.DestPort
+}
+
+// GetRequest gets the first packet of the client, it sends nothing as there is no handshake.
+// A client waiting for the server to speak first isn't supported.
+func (r *Request) GetRequest(_ io.Writer, rd *bufio.Reader) (err error) {
+	if r.RequestData == nil {
+		r.RequestData, err = bufconn.ReceiveData(rd)
+	}
+	return
+}
+
+// Payload is the first packet of the client got by GetRequest.
+func (r *Request) Payload() []byte {
+	return r.RequestData
+}
+
+// Send the request to a upstream server.
+func (r *Request) Send(c *bufconn.Conn, _, seg bool) (err error) {
+	if seg {
+		i := protocol.SplitIndex(r.RequestData, r.DestHost)
+		_, err = c.SplitWrite(r.RequestData, i)
+	} else {
+		_, err = c.Write(r.RequestData)
+	}
+	return
+}
+
+// Request to a upstream server.
+func (r *Request) Request(fw *forwarder.Forwarder, _, seg bool) (restart bool, err error) {
+	_ = fw.LeftConn.SetDeadline(time.Now().Add(2 * fw.Timeout))
+	_ = fw.RightConn.SetDeadline(time.Now().Add(fw.Timeout))
+	err = r.Send(fw.RightConn, false, seg)
+	if err == nil {
+		restart, err = fw.Tunnel()
+	}
+	return
+}
+
+// Reject does nothing, the client sees the connection closed.
+func (r *Request) Reject(_ io.Writer, _ string) error {
+	return nil
+}
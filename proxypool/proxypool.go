@@ -15,6 +15,7 @@ import (
 
 	"github.com/lifenjoiner/ewma"
 	"github.com/lifenjoiner/pd/checker"
+	"github.com/lifenjoiner/pd/network"
 )
 
 const ewmaSlide int = 10
@@ -35,9 +36,10 @@ func (p *Proxy) Dup() *Proxy {
 	return &Proxy{&e, &u, s}
 }
 
-// Check the avalability of a Proxy.
-func (p *Proxy) Check(target *url.URL, timeout time.Duration) error {
+// Check the avalability of a Proxy, a nil dialer is the system dialer.
+func (p *Proxy) Check(nd network.Dialer, target *url.URL, timeout time.Duration) error {
 	ck := checker.NewTargetChecker(p.URL, timeout, nil, target)
+	ck.Dialer = nd
 	return ck.Check()
 }
 
@@ -79,6 +81,7 @@ type ProxyPool struct {
 	Checker       string
 	ProxyProbeURL *url.URL
 	Timeout       time.Duration
+	Dialer        network.Dialer
 }
 
 // GetProxy gets a proxy by index mapping.
@@ -92,6 +95,25 @@ func (pp *ProxyPool) GetProxy(i int) (p *Proxy) {
 	return
 }
 
+// List the proxies in pool.
+func (pp *ProxyPool) List() []*Proxy {
+	pp.RLock()
+	defer pp.RUnlock()
+	return append([]*Proxy(nil), pp.Proxies...)
+}
+
+// FindProxy finds a proxy by its URL.
+func (pp *ProxyPool) FindProxy(u string) (p *Proxy) {
+	pp.RLock()
+	defer pp.RUnlock()
+	for _, x := range pp.Proxies {
+		if x.URL.String() == u {
+			return x
+		}
+	}
+	return nil
+}
+
 // Sort the proxies in pool.
 func (pp *ProxyPool) Sort() {
 	pp.Lock()
@@ -129,7 +151,7 @@ func (pp *ProxyPool) Update() {
 			startTime := time.Now()
 			// Dial -> Handshake -> Transfer
 			d := 3 * pp.Timeout
-			if p.Check(pp.ProxyProbeURL, pp.Timeout) == nil {
+			if p.Check(pp.Dialer, pp.ProxyProbeURL, pp.Timeout) == nil {
 				d = time.Since(startTime)
 			}
 			pp.UpdateProxy(p, d)
@@ -147,8 +169,8 @@ func (pp *ProxyPool) Update() {
 	pp.Unlock()
 }
 
-// InitProxyPool initializes a ProxyPool from configured URLs.
-func InitProxyPool(urls string, test string, timeout time.Duration) (pp map[string]*ProxyPool) {
+// InitProxyPool initializes a ProxyPool from configured URLs, checking by the dialer, a nil one is the system dialer.
+func InitProxyPool(urls string, test string, timeout time.Duration, nd network.Dialer) (pp map[string]*ProxyPool) {
 	pp = make(map[string]*ProxyPool)
 
 	ut, err := url.Parse(test)
@@ -197,6 +219,7 @@ func InitProxyPool(urls string, test string, timeout time.Duration) (pp map[stri
 		pp[s].Lock()
 		pp[s].ProxyProbeURL = ut
 		pp[s].Timeout = timeout
+		pp[s].Dialer = nd
 		pp[s].Unlock()
 		go func() {
 			for {
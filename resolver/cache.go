@@ -0,0 +1,135 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// unknownTTL is returned by the lookups without TTL, like the system resolver.
+const unknownTTL time.Duration = -1
+
+// cacheEntry is a cached lookup result.
+type cacheEntry struct {
+	addrs   []string
+	err     error
+	expires time.Time
+}
+
+// cacheCall is an in-flight lookup, that the concurrent ones for the same host wait for.
+type cacheCall struct {
+	wg    sync.WaitGroup
+	addrs []string
+	err   error
+}
+
+// Cache caches the lookups honouring the TTLs, and the not found ones briefly.
+// The concurrent lookups for the same host are coalesced.
+// The entries are of a Resolver, don't share a Cache between Resolvers trusted differently.
+type Cache struct {
+	hits   uint64 // 64-bit aligned first for atomic operations on 32-bit platforms
+	misses uint64
+	sync.Mutex
+	Name        string        // in the logs
+	DefaultTTL  time.Duration // for the lookups without TTL
+	NegativeTTL time.Duration
+	entries     map[string]*cacheEntry
+	calls       map[string]*cacheCall
+}
+
+// NewCache generates a new Cache.
+func NewCache(defaultTTL, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		DefaultTTL:  defaultTTL,
+		NegativeTTL: negativeTTL,
+		entries:     make(map[string]*cacheEntry),
+		calls:       make(map[string]*cacheCall),
+	}
+}
+
+// Lookup gets the cached result of a host, or looks it up.
+func (c *Cache) Lookup(ctx context.Context, host string, lookup func(context.Context, string) ([]string, time.Duration, error)) ([]string, error) {
+	c.Lock()
+	if e := c.entries[host]; e != nil && time.Now().Before(e.expires) {
+		c.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return copyAddrs(e.addrs), e.err
+	}
+	atomic.AddUint64(&c.misses, 1)
+	if call := c.calls[host]; call != nil {
+		c.Unlock()
+		call.wg.Wait()
+		return copyAddrs(call.addrs), call.err
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[host] = call
+	c.Unlock()
+
+	var ttl time.Duration
+	call.addrs, ttl, call.err = lookup(ctx, host)
+	if ttl == unknownTTL {
+		ttl = c.DefaultTTL
+	}
+	if call.err != nil {
+		ttl = 0
+		if dnsErr, ok := call.err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			ttl = c.NegativeTTL
+		}
+	}
+
+	c.Lock()
+	if ttl > 0 {
+		c.entries[host] = &cacheEntry{call.addrs, call.err, time.Now().Add(ttl)}
+	}
+	delete(c.calls, host)
+	c.Unlock()
+	call.wg.Done()
+	return copyAddrs(call.addrs), call.err
+}
+
+func copyAddrs(addrs []string) []string {
+	if addrs == nil {
+		return nil
+	}
+	return append([]string(nil), addrs...)
+}
+
+// Stats returns the hit/miss counts and the number of entries.
+func (c *Cache) Stats() (hits, misses uint64, n int) {
+	c.Lock()
+	n = len(c.entries)
+	c.Unlock()
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses), n
+}
+
+// Cleanup cleans the expired entries up.
+func (c *Cache) Cleanup() {
+	now := time.Now()
+	c.Lock()
+	for h, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, h)
+		}
+	}
+	c.Unlock()
+}
+
+// StartCleanup cleans up and logs the stats periodically.
+func (c *Cache) StartCleanup(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			c.Cleanup()
+			hits, misses, n := c.Stats()
+			log.Printf("[resolver] cache %v: %v entries, %v hits, %v misses", c.Name, n, hits, misses)
+		}
+	}()
+}
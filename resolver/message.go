@@ -0,0 +1,123 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// DNS types and classes used.
+const (
+	TypeA     uint16 = 1
+	TypeCNAME uint16 = 5
+	TypeAAAA  uint16 = 28
+	ClassINET uint16 = 1
+)
+
+// DNS response codes used.
+const (
+	RcodeSuccess  = 0
+	RcodeNXDomain = 3
+)
+
+const headerLen = 12
+
+var errMalformed = errors.New("malformed DNS message")
+
+// NewQuery packs a recursive query for the name.
+func NewQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	b := make([]byte, headerLen, headerLen+len(name)+6)
+	binary.BigEndian.PutUint16(b[0:], id)
+	b[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(b[4:], 1)
+	name = strings.TrimSuffix(name, ".")
+	if len(name) == 0 || len(name) > 253 {
+		return nil, errors.New("invalid DNS name: " + name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, errors.New("invalid DNS name: " + name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0, byte(qtype>>8), byte(qtype), byte(ClassINET>>8), byte(ClassINET))
+	return b, nil
+}
+
+// Answer is the parsed result of a response.
+type Answer struct {
+	ID        uint16
+	Rcode     int
+	Truncated bool
+	Addrs     []string
+	TTL       uint32 // the minimum of the addresses
+}
+
+// skipName skips a (compressed) name, and returns the next offset.
+func skipName(b []byte, i int) (int, error) {
+	for {
+		if i >= len(b) {
+			return 0, errMalformed
+		}
+		l := int(b[i])
+		switch {
+		case l == 0:
+			return i + 1, nil
+		case l&0xC0 == 0xC0:
+			return i + 2, nil
+		case l&0xC0 != 0:
+			return 0, errMalformed
+		}
+		i += 1 + l
+	}
+}
+
+// ParseResponse parses the A/AAAA addresses out of a response.
+func ParseResponse(b []byte) (*Answer, error) {
+	if len(b) < headerLen || b[2]&0x80 == 0 {
+		return nil, errMalformed
+	}
+	a := &Answer{
+		ID:        binary.BigEndian.Uint16(b[0:]),
+		Rcode:     int(b[3] & 0x0F),
+		Truncated: b[2]&0x02 != 0,
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+	i := headerLen
+	var err error
+	for q := 0; q < qdcount; q++ {
+		i, err = skipName(b, i)
+		if err != nil {
+			return nil, err
+		}
+		i += 4
+	}
+	for n := 0; n < ancount; n++ {
+		i, err = skipName(b, i)
+		if err != nil || i+10 > len(b) {
+			return nil, errMalformed
+		}
+		rtype := binary.BigEndian.Uint16(b[i:])
+		ttl := binary.BigEndian.Uint32(b[i+4:])
+		rdlen := int(binary.BigEndian.Uint16(b[i+8:]))
+		i += 10
+		if i+rdlen > len(b) {
+			return nil, errMalformed
+		}
+		if (rtype == TypeA && rdlen == 4) || (rtype == TypeAAAA && rdlen == 16) {
+			a.Addrs = append(a.Addrs, net.IP(b[i:i+rdlen]).String())
+			if len(a.Addrs) == 1 || ttl < a.TTL {
+				a.TTL = ttl
+			}
+		}
+		i += rdlen
+	}
+	return a, nil
+}
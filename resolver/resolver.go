@@ -0,0 +1,146 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package resolver looks up hosts by configurable DNS servers: UDP, TCP, DNS-over-TLS and DNS-over-HTTPS.
+package resolver
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lifenjoiner/pd/network"
+)
+
+// Resolver looks up hosts by the upstreams in order, or by the system resolver if there isn't any.
+// The upstreams made by New dial by the Dialer, and their proxies by the ProxyDialer, a nil one is the system dialer.
+type Resolver struct {
+	Upstreams   []Upstream
+	Timeout     time.Duration
+	Cache       *Cache
+	Dialer      network.Dialer
+	ProxyDialer network.Dialer
+}
+
+// New generates a Resolver from comma separated upstream URLs.
+func New(upstreams string, timeout time.Duration) (*Resolver, error) {
+	r := &Resolver{Timeout: timeout}
+	for _, s := range strings.Split(upstreams, ",") {
+		if len(s) == 0 {
+			continue
+		}
+		u, err := parseUpstream(s, timeout, r)
+		if err != nil {
+			return nil, err
+		}
+		r.Upstreams = append(r.Upstreams, u)
+	}
+	return r, nil
+}
+
+// LookupHost looks up the IPs of a host.
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if r.Cache != nil {
+		return r.Cache.Lookup(ctx, host, r.lookup)
+	}
+	addrs, _, err := r.lookup(ctx, host)
+	return addrs, err
+}
+
+// lookup looks up the IPs of a host, and the TTL if known.
+func (r *Resolver) lookup(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, 0, nil
+	}
+	if len(r.Upstreams) == 0 {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		return addrs, unknownTTL, err
+	}
+	var err error
+	for _, u := range r.Upstreams {
+		var addrs []string
+		var ttl time.Duration
+		addrs, ttl, err = r.exchange(ctx, u, host)
+		if err == nil {
+			return addrs, ttl, nil
+		}
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return nil, 0, err
+		}
+	}
+	return nil, 0, err
+}
+
+// exchange queries A and AAAA of a host by an upstream.
+func (r *Resolver) exchange(ctx context.Context, u Upstream, host string) ([]string, time.Duration, error) {
+	type result struct {
+		a   *Answer
+		err error
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	qtypes := [2]uint16{TypeA, TypeAAAA}
+	results := make(chan result, len(qtypes))
+	for _, qtype := range qtypes {
+		msg, err := NewQuery(uint16(rand.Uint32()), host, qtype)
+		if err != nil {
+			return nil, 0, &net.DNSError{Err: err.Error(), Name: host, IsNotFound: true}
+		}
+		go func() {
+			b, err := u.Exchange(ctx, msg)
+			if err != nil {
+				results <- result{nil, err}
+				return
+			}
+			a, err := ParseResponse(b)
+			if err == nil && a.ID != uint16(msg[0])<<8|uint16(msg[1]) {
+				err = errMalformed
+			}
+			results <- result{a, err}
+		}()
+	}
+
+	var addrs []string
+	var ttl uint32
+	var err error
+	nx := 0
+	for range qtypes {
+		res := <-results
+		if res.err != nil {
+			err = res.err
+			continue
+		}
+		switch res.a.Rcode {
+		case RcodeSuccess:
+		case RcodeNXDomain:
+			nx++
+			continue
+		default:
+			err = &net.DNSError{Err: "server misbehaving", Name: host, Server: u.String(), IsTemporary: true}
+			continue
+		}
+		if len(res.a.Addrs) > 0 && (len(addrs) == 0 || res.a.TTL < ttl) {
+			ttl = res.a.TTL
+		}
+		addrs = append(addrs, res.a.Addrs...)
+	}
+	if len(addrs) > 0 {
+		return addrs, time.Duration(ttl) * time.Second, nil
+	}
+	if err == nil || nx == len(qtypes) {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: u.String(), IsNotFound: true}
+	}
+	if _, ok := err.(*net.DNSError); !ok {
+		err = &net.DNSError{Err: err.Error(), Name: host, Server: u.String(), IsTimeout: isTimeout(err)}
+	}
+	return nil, 0, err
+}
+
+func isTimeout(err error) bool {
+	e, ok := err.(net.Error)
+	return ok && e.Timeout()
+}
@@ -0,0 +1,271 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/network"
+)
+
+// stubAnswer answers the stub zone: `example.com` and `big.example.com` (truncated over UDP).
+func stubAnswer(q []byte, udp bool) []byte {
+	i, err := skipName(q, headerLen)
+	if err != nil {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(q[i:])
+	var name []string
+	for j := headerLen; q[j] > 0; j += int(q[j]) + 1 {
+		name = append(name, string(q[j+1:j+1+int(q[j])]))
+	}
+	host := strings.Join(name, ".")
+
+	r := make([]byte, i+4)
+	copy(r, q[:i+4])
+	r[2] |= 0x80
+	var rdata [][]byte
+	switch host {
+	case "example.com", "big.example.com":
+		if host == "big.example.com" && udp {
+			r[2] |= 0x02
+			break
+		}
+		if qtype == TypeA {
+			rdata = append(rdata, net.ParseIP("1.2.3.4").To4(), net.ParseIP("5.6.7.8").To4())
+		} else if qtype == TypeAAAA {
+			rdata = append(rdata, net.ParseIP("2001:db8::1"))
+		}
+	default:
+		r[3] |= RcodeNXDomain
+	}
+	binary.BigEndian.PutUint16(r[6:], uint16(len(rdata)))
+	for k, d := range rdata {
+		rr := []byte{0xC0, headerLen, byte(qtype >> 8), byte(qtype), 0, 1, 0, 0, 0, byte(60 * (k + 1)), 0, byte(len(d))}
+		r = append(r, rr...)
+		r = append(r, d...)
+	}
+	return r
+}
+
+// serveStream answers a query over TCP.
+func serveStream(c net.Conn) {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return
+	}
+	b = make([]byte, binary.BigEndian.Uint16(b))
+	if _, err := io.ReadFull(c, b); err != nil {
+		return
+	}
+	r := stubAnswer(b, false)
+	_, _ = c.Write(append([]byte{byte(len(r) >> 8), byte(len(r))}, r...))
+}
+
+func startStubDNS(t *testing.T) (addr string, stop func()) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr = pc.LocalAddr().String()
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		pc.Close()
+		t.Fatal(err)
+	}
+	go func() {
+		b := make([]byte, 512)
+		for {
+			n, a, err := pc.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(stubAnswer(b[:n], true), a)
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				serveStream(c)
+			}()
+		}
+	}()
+	return addr, func() {
+		pc.Close()
+		l.Close()
+	}
+}
+
+func checkLookup(t *testing.T, r *Resolver) {
+	ctx := context.Background()
+	for _, host := range []string{"example.com", "big.example.com"} {
+		addrs, ttl, err := r.lookup(ctx, host)
+		log.Printf("%v %v: %v %v %v", r.Upstreams[0], host, addrs, ttl, err)
+		sort.Strings(addrs)
+		if err != nil || strings.Join(addrs, ",") != "1.2.3.4,2001:db8::1,5.6.7.8" || ttl != time.Minute {
+			t.Errorf("%v %v: %v %v %v", r.Upstreams[0], host, addrs, ttl, err)
+		}
+	}
+	_, err := r.LookupHost(ctx, "nx.example.org")
+	if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+		t.Errorf("%v nx.example.org: %v", r.Upstreams[0], err)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	addr, stop := startStubDNS(t)
+	defer stop()
+
+	for _, s := range []string{addr, "tcp://" + addr} {
+		r, err := New(s, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkLookup(t, r)
+	}
+
+	// DoH
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		b, _ := io.ReadAll(req.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(stubAnswer(b, false))
+	}))
+	defer srv.Close()
+	r := &Resolver{Timeout: time.Second}
+	r.Upstreams = append(r.Upstreams, &HTTPSUpstream{URL: srv.URL + "/dns-query", Client: srv.Client()})
+	checkLookup(t, r)
+
+	// Fall back to the next upstream.
+	l, _ := net.Listen("tcp4", "127.0.0.1:0")
+	l.Close()
+	r, _ = New("tcp://"+l.Addr().String()+","+addr, time.Second)
+	checkLookup(t, r)
+}
+
+func TestResolverDialer(t *testing.T) {
+	f := network.NewFake()
+	f.Handle("192.0.2.53:53", network.Accept, serveStream)
+	r, err := New("tcp://192.0.2.53", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Dialer = f
+	checkLookup(t, r)
+	if f.Dials("192.0.2.53:53") == 0 {
+		t.Error("not dialed by the Dialer")
+	}
+
+	// The proxy is dialed by the ProxyDialer.
+	p := network.NewFake()
+	p.Handle("192.0.2.9:1080", network.Accept, func(c net.Conn) {
+		b := make([]byte, 1024)
+		_, _ = c.Read(b)
+		_, _ = c.Write([]byte{5, 0})
+		_, _ = c.Read(b)
+		_, _ = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		serveStream(c)
+	})
+	r, err = New("tcp://192.0.2.53?proxy=socks5://192.0.2.9:1080", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Dialer = f
+	r.ProxyDialer = p
+	checkLookup(t, r)
+	if p.Dials("192.0.2.9:1080") == 0 {
+		t.Error("not dialed by the ProxyDialer")
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	tests := map[string]string{
+		"1.1.1.1":       "udp://1.1.1.1:53",
+		"tcp://1.1.1.1": "tcp://1.1.1.1:53",
+		"tls://1.1.1.1": "tls://1.1.1.1:853",
+		"tls://dns.google:853?proxy=socks5://127.0.0.1:1080": "tls://dns.google:853?proxy=socks5://127.0.0.1:1080",
+		"https://dns.google/dns-query":                       "https://dns.google/dns-query",
+	}
+	for s, expected := range tests {
+		u, err := ParseUpstream(s, time.Second)
+		if err != nil || u.String() != expected {
+			t.Errorf("%v: %v %v, expected = %v", s, u, err, expected)
+		}
+	}
+	if _, err := ParseUpstream("udp://1.1.1.1?proxy=socks5://127.0.0.1:1080", time.Second); err == nil {
+		t.Fail()
+	}
+}
+
+func TestCache(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	lookup := func(ctx context.Context, host string) ([]string, time.Duration, error) {
+		mu.Lock()
+		calls[host]++
+		mu.Unlock()
+		time.Sleep(30 * time.Millisecond)
+		switch host {
+		case "example.com":
+			return []string{"1.2.3.4"}, time.Minute, nil
+		case "system.example.com":
+			return []string{"5.6.7.8"}, unknownTTL, nil
+		case "zero.example.com":
+			return []string{"5.6.7.8"}, 0, nil
+		case "timeout.example.com":
+			return nil, 0, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+		}
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	c := NewCache(time.Minute, 200*time.Millisecond)
+	hosts := []string{"example.com", "system.example.com", "zero.example.com", "timeout.example.com", "nx.example.com"}
+	for round := 0; round < 2; round++ {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			for _, h := range hosts {
+				wg.Add(1)
+				go func(h string) {
+					defer wg.Done()
+					_, _ = c.Lookup(context.Background(), h, lookup)
+				}(h)
+			}
+		}
+		wg.Wait()
+	}
+	hits, misses, n := c.Stats()
+	log.Printf("calls: %v, hits: %v, misses: %v, entries: %v", calls, hits, misses, n)
+	expected := map[string]int{"example.com": 1, "system.example.com": 1, "zero.example.com": 2, "timeout.example.com": 2, "nx.example.com": 1}
+	for h, k := range expected {
+		if calls[h] != k {
+			t.Errorf("%v: %v calls, expected = %v", h, calls[h], k)
+		}
+	}
+	if hits+misses != 100 || n != 3 {
+		t.Fail()
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	c.Cleanup()
+	_, err := c.Lookup(context.Background(), "nx.example.com", lookup)
+	if _, _, n = c.Stats(); n != 3 || calls["nx.example.com"] != 2 || err == nil {
+		t.Fail()
+	}
+}
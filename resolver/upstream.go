@@ -0,0 +1,299 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/protocol"
+)
+
+// Upstream exchanges DNS messages with a server.
+type Upstream interface {
+	Exchange(ctx context.Context, msg []byte) ([]byte, error)
+	String() string
+}
+
+// readerConn reads through the buffered reader of a bufconn.Conn.
+type readerConn struct {
+	*bufconn.Conn
+}
+
+func (c readerConn) Read(b []byte) (int, error) {
+	return c.R.Read(b)
+}
+
+// dialer dials the server directly by the Dialer of the resolver, or through the upstream proxy by its ProxyDialer.
+type dialer struct {
+	Proxy   *url.URL
+	Timeout time.Duration
+	r       *Resolver
+}
+
+// netDialer is the Dialer of the resolver, nil for the system dialer.
+func (r *Resolver) netDialer() network.Dialer {
+	if r == nil {
+		return nil
+	}
+	return r.Dialer
+}
+
+// proxyDialer is the ProxyDialer of the resolver, nil for the system dialer.
+func (r *Resolver) proxyDialer() network.Dialer {
+	if r == nil {
+		return nil
+	}
+	return r.ProxyDialer
+}
+
+// dialTimeout dials with the timeout and the ctx by the Dialer of the resolver.
+func (r *Resolver) dialTimeout(ctx context.Context, proto, addr string, timeout time.Duration) (net.Conn, error) {
+	nd := r.netDialer()
+	if nd == nil {
+		nd = network.SystemDialer
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return nd.DialContext(ctx, proto, addr)
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if d.Proxy == nil {
+		return d.r.dialTimeout(ctx, network, addr, d.Timeout)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var cs bufconn.ConnSolver
+	switch d.Proxy.Scheme {
+	case "http":
+		cs, err = bufconn.DialHTTP(d.r.proxyDialer(), d.Proxy, d.Timeout)
+	case "socks5":
+		cs, err = bufconn.DialSocks5(d.r.proxyDialer(), d.Proxy, d.Timeout)
+	case "socks4a":
+		cs, err = bufconn.DialSocks4a(d.r.proxyDialer(), d.Proxy, d.Timeout)
+	default:
+		err = errors.New("resolver: unsupported proxy: " + d.Proxy.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := cs.GetConn()
+	err = cs.Bond("CONNECT", host, port, nil)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	_ = c.SetDeadline(time.Time{})
+	return readerConn{c}, nil
+}
+
+// UDPUpstream is a plain DNS server over UDP, that falls back to TCP for truncated responses.
+type UDPUpstream struct {
+	Addr    string
+	Timeout time.Duration
+	r       *Resolver
+}
+
+// Exchange a message.
+func (u *UDPUpstream) Exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	c, err := u.r.dialTimeout(ctx, "udp", u.Addr, u.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	setDeadline(ctx, c, u.Timeout)
+	_, err = c.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 1232)
+	for {
+		n, err := c.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		// Drop the mismatched (forged or late) ones.
+		if n < headerLen || b[0] != msg[0] || b[1] != msg[1] {
+			continue
+		}
+		if b[2]&0x02 != 0 {
+			t := &StreamUpstream{Addr: u.Addr, Timeout: u.Timeout, r: u.r}
+			return t.Exchange(ctx, msg)
+		}
+		return b[:n], nil
+	}
+}
+
+func (u *UDPUpstream) String() string {
+	return "udp://" + u.Addr
+}
+
+// StreamUpstream is a plain DNS server over TCP, or a DNS-over-TLS server.
+type StreamUpstream struct {
+	Addr       string
+	ServerName string
+	TLS        bool
+	Proxy      *url.URL
+	Timeout    time.Duration
+	r          *Resolver
+}
+
+// Exchange a message.
+func (u *StreamUpstream) Exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	d := &dialer{u.Proxy, u.Timeout, u.r}
+	c, err := d.DialContext(ctx, "tcp", u.Addr)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if u.TLS {
+		c = tls.Client(c, &tls.Config{ServerName: u.ServerName})
+	}
+	setDeadline(ctx, c, u.Timeout)
+	b := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(b, uint16(len(msg)))
+	copy(b[2:], msg)
+	_, err = c.Write(b)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.ReadFull(c, b[:2])
+	if err != nil {
+		return nil, err
+	}
+	b = make([]byte, binary.BigEndian.Uint16(b))
+	_, err = io.ReadFull(c, b)
+	return b, err
+}
+
+func (u *StreamUpstream) String() string {
+	s := "tcp://"
+	if u.TLS {
+		s = "tls://"
+	}
+	s += u.Addr
+	if u.Proxy != nil {
+		s += "?proxy=" + u.Proxy.String()
+	}
+	return s
+}
+
+// HTTPSUpstream is a DNS-over-HTTPS server (RFC 8484).
+type HTTPSUpstream struct {
+	URL    string
+	Proxy  *url.URL
+	Client *http.Client
+}
+
+// Exchange a message.
+func (u *HTTPSUpstream) Exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u.URL, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	res, err := u.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("resolver: DoH server responded: " + res.Status)
+	}
+	return io.ReadAll(io.LimitReader(res.Body, 65535))
+}
+
+func (u *HTTPSUpstream) String() string {
+	s := u.URL
+	if u.Proxy != nil {
+		s += "?proxy=" + u.Proxy.String()
+	}
+	return s
+}
+
+func setDeadline(ctx context.Context, c net.Conn, d time.Duration) {
+	t := time.Now().Add(d)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(t) {
+		t = dl
+	}
+	_ = c.SetDeadline(t)
+}
+
+// ParseUpstream parses an upstream URL:
+// [udp://]Host[:Port], tcp://Host[:Port], tls://Host[:Port], https://Host[:Port]/Path.
+// tcp, tls and https servers can be reached through a proxy by the query `?proxy=Scheme://Host:Port`.
+func ParseUpstream(s string, timeout time.Duration) (Upstream, error) {
+	return parseUpstream(s, timeout, nil)
+}
+
+// parseUpstream parses an upstream URL, that dials by the dialers of the resolver r.
+func parseUpstream(s string, timeout time.Duration, r *Resolver) (Upstream, error) {
+	if !strings.Contains(s, "//") {
+		s = "udp://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	var proxy *url.URL
+	q := u.Query()
+	if p := q.Get("proxy"); len(p) > 0 {
+		proxy, err = url.Parse(p)
+		if err != nil {
+			return nil, err
+		}
+		q.Del("proxy")
+		u.RawQuery = q.Encode()
+	}
+	port := u.Port()
+	if len(port) == 0 {
+		switch u.Scheme {
+		case "tls":
+			port = "853"
+		case "https":
+			port = protocol.GetPort(u)
+		default:
+			port = "53"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	switch u.Scheme {
+	case "udp":
+		if proxy != nil {
+			return nil, errors.New("resolver: UDP can't go proxied: " + s)
+		}
+		return &UDPUpstream{addr, timeout, r}, nil
+	case "tcp", "tls":
+		return &StreamUpstream{addr, u.Hostname(), u.Scheme == "tls", proxy, timeout, r}, nil
+	case "https":
+		d := &dialer{proxy, timeout, r}
+		client := &http.Client{
+			Transport: &http.Transport{
+				DialContext:         d.DialContext,
+				ForceAttemptHTTP2:   true,
+				TLSHandshakeTimeout: timeout,
+				IdleConnTimeout:     time.Minute,
+			},
+			Timeout: 2 * timeout,
+		}
+		return &HTTPSUpstream{u.String(), proxy, client}, nil
+	}
+	return nil, errors.New("resolver: unsupported upstream: " + s)
+}
@@ -0,0 +1,96 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+// ACL allows or denies clients by IP. Deny goes first, and an empty Allow allows all.
+type ACL struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+// parseIPNets parses comma separated IPs or CIDRs.
+func parseIPNets(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if len(v) == 0 {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			IP := net.ParseIP(v)
+			if IP == nil {
+				return nil, errors.New("invalid IP: " + v)
+			}
+			bits := 128
+			if IP.To4() != nil {
+				IP = IP.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: IP, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ParseACL parses the comma separated IPs or CIDRs to allow and deny.
+func ParseACL(allow, deny string) (*ACL, error) {
+	var err error
+	acl := &ACL{}
+	acl.Allow, err = parseIPNets(allow)
+	if err != nil {
+		return nil, err
+	}
+	acl.Deny, err = parseIPNets(deny)
+	if err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+func containsIP(nets []*net.IPNet, IP net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed tells if a client address is allowed. A nil ACL allows all.
+func (acl *ACL) Allowed(addr net.Addr) bool {
+	if acl == nil {
+		return true
+	}
+	var IP net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		IP = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			host = addr.String()
+		}
+		IP = net.ParseIP(host)
+	}
+	if IP == nil {
+		return false
+	}
+	if containsIP(acl.Deny, IP) {
+		return false
+	}
+	return len(acl.Allow) == 0 || containsIP(acl.Allow, IP)
+}
@@ -0,0 +1,39 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestACL(t *testing.T) {
+	acl, err := ParseACL("192.168.2.0/24,127.0.0.1,::1", "192.168.2.100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"192.168.2.1":   true,
+		"192.168.2.100": false,
+		"192.168.3.1":   false,
+		"127.0.0.1":     true,
+		"::1":           true,
+		"fe80::1":       false,
+	}
+	for ip, allowed := range cases {
+		addr := &net.TCPAddr{IP: net.ParseIP(ip), Port: 6699}
+		if acl.Allowed(addr) != allowed {
+			t.Errorf("%v: expected %v", ip, allowed)
+		}
+	}
+
+	acl, _ = ParseACL("", "10.0.0.0/8")
+	if acl.Allowed(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}) || !acl.Allowed(&net.TCPAddr{IP: net.ParseIP("8.8.8.8")}) {
+		t.Fail()
+	}
+	if _, err = ParseACL("300.1.1.1", ""); err == nil {
+		t.Fail()
+	}
+}
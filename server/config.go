@@ -12,7 +12,12 @@ import (
 type Config struct {
 	UpstreamTimeout time.Duration
 	ParallelDial    bool
+	DialDelay       time.Duration
+	IPFamily        string
+	RaceDelay       time.Duration
 	Proxies         string
 	ProxyProbeURL   string
 	PacFile         string
+	ACL             *ACL
+	Limiter         *Limiter
 }
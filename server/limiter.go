@@ -0,0 +1,200 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrLimited is returned when a connection exceeds the limits.
+var ErrLimited = errors.New("too many connections")
+
+// The client entries to keep before cleaning the idle ones up.
+const maxIdleClients = 1024
+
+// bucket is a token bucket for the new connection rate, it bursts up to 1 second of the rate.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// burst is the capacity of a bucket for the rate.
+func burst(rate float64) float64 {
+	if rate < 1 {
+		return 1
+	}
+	return rate
+}
+
+// refill the tokens by the elapsed time.
+func (b *bucket) refill(rate float64, now time.Time) {
+	burst := burst(rate)
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += rate * now.Sub(b.last).Seconds()
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+}
+
+// wait is the time for a token, 0 if there is one. An unlimited rate is <= 0.
+func (b *bucket) wait(rate float64, now time.Time) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	b.refill(rate, now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// full tells if the bucket is refilled up, as if it is untouched.
+func (b *bucket) full(rate float64, now time.Time) bool {
+	if rate <= 0 {
+		return true
+	}
+	b.refill(rate, now)
+	return b.tokens >= burst(rate)
+}
+
+// clientLimit is the state of a client IP.
+type clientLimit struct {
+	conns  int
+	bucket bucket
+}
+
+// Limiter caps the concurrent connections and the new connection rate, globally and per client IP.
+// The zero values are unlimited. Over the limits, a connection waits up to Queue for a slot, or is rejected if Queue is 0.
+type Limiter struct {
+	MaxConns       int
+	MaxClientConns int
+	Rate           float64 // new connections per second
+	ClientRate     float64
+	Queue          time.Duration
+	//local
+	mu      sync.Mutex
+	conns   int
+	bucket  bucket
+	clients map[string]*clientLimit
+	changed chan struct{}
+}
+
+// clientIP gets the IP of an address as the client key.
+func clientIP(addr net.Addr) string {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// tryAcquire takes a slot if available, or returns the time to wait for the rate, 0 for a released connection.
+func (l *Limiter) tryAcquire(ip string, now time.Time) (bool, time.Duration) {
+	if l.clients == nil {
+		l.clients = make(map[string]*clientLimit)
+	}
+	cl := l.clients[ip]
+	if cl == nil {
+		if len(l.clients) >= maxIdleClients {
+			l.cleanup(now)
+		}
+		cl = &clientLimit{}
+		l.clients[ip] = cl
+	}
+	if l.MaxConns > 0 && l.conns >= l.MaxConns || l.MaxClientConns > 0 && cl.conns >= l.MaxClientConns {
+		return false, 0
+	}
+	wait := l.bucket.wait(l.Rate, now)
+	if w := cl.bucket.wait(l.ClientRate, now); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	if l.Rate > 0 {
+		l.bucket.tokens--
+	}
+	if l.ClientRate > 0 {
+		cl.bucket.tokens--
+	}
+	l.conns++
+	cl.conns++
+	return true, 0
+}
+
+// cleanup the idle clients.
+func (l *Limiter) cleanup(now time.Time) {
+	for ip, cl := range l.clients {
+		if cl.conns == 0 && cl.bucket.full(l.ClientRate, now) {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// Acquire takes a slot for a client, waiting up to Queue. A nil Limiter is unlimited.
+func (l *Limiter) Acquire(addr net.Addr) error {
+	if l == nil {
+		return nil
+	}
+	ip := clientIP(addr)
+	deadline := time.Now().Add(l.Queue)
+	for {
+		l.mu.Lock()
+		ok, wait := l.tryAcquire(ip, time.Now())
+		if l.changed == nil {
+			l.changed = make(chan struct{})
+		}
+		changed := l.changed
+		l.mu.Unlock()
+		if ok {
+			return nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrLimited
+		}
+		if wait <= 0 || wait > remaining {
+			wait = remaining
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+// Release returns the slot of a client, and wakes up the waiting ones.
+func (l *Limiter) Release(addr net.Addr) {
+	if l == nil {
+		return
+	}
+	ip := clientIP(addr)
+	l.mu.Lock()
+	l.conns--
+	if cl := l.clients[ip]; cl != nil {
+		cl.conns--
+		if cl.conns <= 0 && cl.bucket.full(l.ClientRate, time.Now()) {
+			delete(l.clients, ip)
+		}
+	}
+	if l.changed != nil {
+		close(l.changed)
+		l.changed = nil
+	}
+	l.mu.Unlock()
+}
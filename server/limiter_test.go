@@ -0,0 +1,55 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	a := &net.TCPAddr{IP: net.ParseIP("192.168.2.2"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("192.168.2.3"), Port: 1}
+
+	l := &Limiter{MaxConns: 3, MaxClientConns: 2}
+	if l.Acquire(a) != nil || l.Acquire(a) != nil {
+		t.Fatal("under the limits")
+	}
+	if l.Acquire(a) != ErrLimited {
+		t.Fatal("over the client limit")
+	}
+	if l.Acquire(b) != nil {
+		t.Fatal("another client")
+	}
+	if l.Acquire(b) != ErrLimited {
+		t.Fatal("over the global limit")
+	}
+
+	l.Queue = time.Second
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		l.Release(a)
+	}()
+	startTime := time.Now()
+	if l.Acquire(b) != nil || time.Since(startTime) >= l.Queue {
+		t.Fatal("queued for a released slot")
+	}
+
+	l = &Limiter{ClientRate: 10, Queue: time.Second}
+	startTime = time.Now()
+	for i := 0; i < 12; i++ {
+		if l.Acquire(a) != nil {
+			t.Fatal("queued for the rate")
+		}
+	}
+	if d := time.Since(startTime); d < 100*time.Millisecond {
+		t.Fatalf("rate limited in %v", d)
+	}
+	l.Queue = 0
+	if l.Acquire(a) != ErrLimited {
+		t.Fatal("over the rate")
+	}
+}
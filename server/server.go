@@ -5,11 +5,18 @@
 // Package server model.
 package server
 
-import "sync"
+import (
+	"sync"
 
-// Server stores the pd server config.
+	"github.com/lifenjoiner/pd/dispatcher"
+)
+
+// Server stores the pd server config. A nil Engine falls back to the dispatcher global parameters.
+// A Transparent one accepts the connections redirected by iptables, rather than the proxy requests.
 type Server struct {
-	WG     *sync.WaitGroup
-	Addr   string
-	Config *Config
+	WG          *sync.WaitGroup
+	Addr        string
+	Transparent bool
+	Config      *Config
+	Engine      *dispatcher.Engine
 }
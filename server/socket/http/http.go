@@ -6,6 +6,7 @@
 package http
 
 import (
+	"encoding/json"
 	"log"
 	"os"
 
@@ -31,11 +32,14 @@ func (s *Server) Serve(c *bufconn.Conn) bool {
 		if len(s.Config.PacFile) > 0 && len(u.Path) > 1 && u.Path[0] == '/' && u.Path[1:] == s.Config.PacFile {
 			return s.servePac(c)
 		}
+		if u.Path == "/connectivity" {
+			return s.serveConnectivity(c)
+		}
 		log.Printf("[http] Invalid request.")
 		return false
 	}
 
-	dp := dispatcher.New("http", c, u.Hostname(), u.Port(), s.Config.UpstreamTimeout)
+	dp := dispatcher.New(s.Engine, "http", c, u.Hostname(), u.Port(), s.Config.UpstreamTimeout)
 	if dp.DestPort == "" && req.Method != "CONNECT" {
 		if u.Scheme == "" {
 			u.Scheme = "http"
@@ -43,6 +47,9 @@ func (s *Server) Serve(c *bufconn.Conn) bool {
 		dp.DestPort = u.Scheme
 	}
 	dp.ParallelDial = s.Config.ParallelDial
+	dp.DialDelay = s.Config.DialDelay
+	dp.IPFamily = s.Config.IPFamily
+	dp.RaceDelay = s.Config.RaceDelay
 	return dp.Dispatch(req)
 }
 
@@ -61,3 +68,33 @@ func (s *Server) servePac(c *bufconn.Conn) bool {
 	log.Printf("[http] Pac file: %v", err)
 	return false
 }
+
+func (s *Server) serveConnectivity(c *bufconn.Conn) bool {
+	e := s.Engine
+	if e == nil {
+		e = dispatcher.Globals()
+	}
+	b, err := json.Marshal(e.Connectivity.Status())
+	if err == nil {
+		_, err = c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Type: application/json\r\nConnection: close\r\n\r\n"))
+		if err == nil {
+			_, err = c.Write(b)
+			if err == nil {
+				return true
+			}
+		}
+	}
+	log.Printf("[http] connectivity: %v", err)
+	return false
+}
+
+// Refuse replies a client "403 Forbidden".
+func (s *Server) Refuse(c *bufconn.Conn) {
+	_, err := http.ParseRequest(c.R)
+	if err == nil {
+		_, err = c.Write([]byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"))
+	}
+	if err != nil {
+		log.Printf("[http] %v <= %v", c.RemoteAddr(), err)
+	}
+}
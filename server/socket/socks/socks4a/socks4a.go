@@ -31,8 +31,11 @@ func (s *Server) Serve(c *bufconn.Conn) bool {
 	var msg string
 	switch req.Cmd {
 	case socks.CONNECT:
-		dp := dispatcher.New("socks4a", c, req.DestHost, req.DestPort, s.Config.UpstreamTimeout)
+		dp := dispatcher.New(s.Engine, "socks4a", c, req.DestHost, req.DestPort, s.Config.UpstreamTimeout)
 		dp.ParallelDial = s.Config.ParallelDial
+		dp.DialDelay = s.Config.DialDelay
+		dp.IPFamily = s.Config.IPFamily
+		dp.RaceDelay = s.Config.RaceDelay
 		return dp.Dispatch(req)
 	case socks.BIND:
 		msg = "unimplemented BIND"
@@ -42,3 +45,14 @@ func (s *Server) Serve(c *bufconn.Conn) bool {
 	log.Printf("%v <= %v", logPre, msg)
 	return false
 }
+
+// Refuse replies a client "request rejected or failed".
+func (s *Server) Refuse(c *bufconn.Conn) {
+	_, err := socks4a.ParseRequest(c.R)
+	if err == nil {
+		_, err = c.Write([]byte{0, 0x5b, 0, 0, 0, 0, 0, 0})
+	}
+	if err != nil {
+		log.Printf("[socks4a] %v <= %v", c.RemoteAddr(), err)
+	}
+}
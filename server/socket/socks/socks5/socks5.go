@@ -36,8 +36,11 @@ func (s *Server) Serve(c *bufconn.Conn) bool {
 	var msg string
 	switch req.Cmd {
 	case socks.CONNECT:
-		dp := dispatcher.New("socks5", c, req.DestHost, req.DestPort, s.Config.UpstreamTimeout)
+		dp := dispatcher.New(s.Engine, "socks5", c, req.DestHost, req.DestPort, s.Config.UpstreamTimeout)
 		dp.ParallelDial = s.Config.ParallelDial
+		dp.DialDelay = s.Config.DialDelay
+		dp.IPFamily = s.Config.IPFamily
+		dp.RaceDelay = s.Config.RaceDelay
 		return dp.Dispatch(req)
 	case socks.BIND:
 		msg = "unimplemented BIND"
@@ -49,3 +52,17 @@ func (s *Server) Serve(c *bufconn.Conn) bool {
 	log.Printf("%v <= %v", logPre, msg)
 	return false
 }
+
+// Refuse replies a client "connection not allowed by ruleset".
+func (s *Server) Refuse(c *bufconn.Conn) {
+	err := socks5.Authorize(c, c.R)
+	if err == nil {
+		_, err = socks5.ParseRequest(c.R)
+		if err == nil {
+			_, err = c.Write([]byte{5, 2, 0, 1, 0, 0, 0, 0, 0, 0})
+		}
+	}
+	if err != nil {
+		log.Printf("[socks5] %v <= %v", c.RemoteAddr(), err)
+	}
+}
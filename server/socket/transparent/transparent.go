@@ -0,0 +1,81 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+// Package transparent serves the connections redirected by iptables REDIRECT or TPROXY.
+package transparent
+
+import (
+	"errors"
+	"log"
+	"net"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/dispatcher"
+	"github.com/lifenjoiner/pd/protocol/transparent"
+	"github.com/lifenjoiner/pd/server"
+)
+
+// ErrNotRedirected is the error of a connection made to the listener itself.
+var ErrNotRedirected = errors.New("not redirected")
+
+// Server struct.
+type Server server.Server
+
+// Serve serves 1 client.
+func (s *Server) Serve(c *bufconn.Conn) bool {
+	logPre := "[transparent] " + c.RemoteAddr().String()
+
+	dst, err := s.OriginalDst(c.Conn)
+	if err != nil {
+		log.Printf("%v <= %v", logPre, err)
+		return false
+	}
+	host, port, _ := net.SplitHostPort(dst.String())
+	req := &transparent.Request{DestHost: host, DestPort: port}
+	dp := dispatcher.New(s.Engine, "transparent", c, host, port, s.Config.UpstreamTimeout)
+	dp.ParallelDial = s.Config.ParallelDial
+	dp.DialDelay = s.Config.DialDelay
+	dp.IPFamily = s.Config.IPFamily
+	dp.RaceDelay = s.Config.RaceDelay
+	return dp.Dispatch(req)
+}
+
+// OriginalDst gets the destination before redirected: by SO_ORIGINAL_DST for REDIRECT,
+// or the local address for TPROXY. A connection to the listener itself isn't redirected.
+func (s *Server) OriginalDst(c net.Conn) (*net.TCPAddr, error) {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil, ErrNotRedirected
+	}
+	dst, err := originalDst(tc)
+	if err != nil {
+		dst, _ = tc.LocalAddr().(*net.TCPAddr)
+	}
+	// With conntrack, SO_ORIGINAL_DST of a connection not redirected is the listener.
+	if dst == nil || s.isListener(dst) {
+		return nil, ErrNotRedirected
+	}
+	return dst, nil
+}
+
+// isListener tells if the address is the listening one, or one of the host for listening on all.
+func (s *Server) isListener(addr *net.TCPAddr) bool {
+	l, err := net.ResolveTCPAddr("tcp", s.Addr)
+	if err != nil || l.Port != addr.Port {
+		return false
+	}
+	if len(l.IP) > 0 && !l.IP.IsUnspecified() {
+		return l.IP.Equal(addr.IP)
+	}
+	if addr.IP.IsLoopback() {
+		return true
+	}
+	ifAddrs, _ := net.InterfaceAddrs()
+	for _, a := range ifAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build linux
+// +build linux
+
+package transparent
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// Supported tells if the transparent proxying is supported on this OS.
+const Supported = true
+
+// SO_ORIGINAL_DST of netfilter, the same value for IPv4 and IPv6.
+const soOriginalDst = 80
+
+// originalDst gets the destination by SO_ORIGINAL_DST of the REDIRECT target.
+func originalDst(c *net.TCPConn) (*net.TCPAddr, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dst *net.TCPAddr
+	cerr := rc.Control(func(fd uintptr) {
+		// Both sockaddr_in and sockaddr_in6 fit in.
+		var sa syscall.RawSockaddrInet6
+		size := uint32(unsafe.Sizeof(sa))
+		level := syscall.SOL_IP
+		if ip := c.LocalAddr().(*net.TCPAddr).IP; ip.To4() == nil {
+			level = syscall.SOL_IPV6
+		}
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, uintptr(level), soOriginalDst,
+			uintptr(unsafe.Pointer(&sa)), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			err = errno
+			return
+		}
+		// In network byte order.
+		p := (*[2]byte)(unsafe.Pointer(&sa.Port))
+		port := int(p[0])<<8 | int(p[1])
+		switch sa.Family {
+		case syscall.AF_INET:
+			sa4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(&sa))
+			dst = &net.TCPAddr{IP: net.IP(sa4.Addr[:]).To16(), Port: port}
+		case syscall.AF_INET6:
+			dst = &net.TCPAddr{IP: net.IP(sa.Addr[:]), Port: port}
+		default:
+			err = syscall.EAFNOSUPPORT
+		}
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	return dst, err
+}
+
+// Control sets IP_TRANSPARENT on the listening socket for TPROXY, it requires CAP_NET_ADMIN.
+func Control(network, _ string, c syscall.RawConn) error {
+	level, opt := syscall.SOL_IP, syscall.IP_TRANSPARENT
+	if network == "tcp6" {
+		level, opt = syscall.SOL_IPV6, ipv6Transparent
+	}
+	var err error
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), level, opt, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// IPV6_TRANSPARENT isn't in syscall.
+const ipv6Transparent = 75
@@ -0,0 +1,29 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package transparent
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Supported tells if the transparent proxying is supported on this OS.
+const Supported = false
+
+var errUnsupported = errors.New("transparent proxying isn't supported on this OS")
+
+// originalDst is unsupported.
+func originalDst(*net.TCPConn) (*net.TCPAddr, error) {
+	return nil, errUnsupported
+}
+
+// Control is unsupported.
+func Control(string, string, syscall.RawConn) error {
+	return errUnsupported
+}
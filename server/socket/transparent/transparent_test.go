@@ -0,0 +1,86 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package transparent
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/dispatcher"
+	"github.com/lifenjoiner/pd/network"
+	"github.com/lifenjoiner/pd/server"
+)
+
+// accept a loopback connection, the local address of it is the destination as by TPROXY.
+func accept(t *testing.T) (client, conn net.Conn) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err = net.Dial("tcp4", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServe(t *testing.T) {
+	client, conn := accept(t)
+	defer client.Close()
+	dst := conn.LocalAddr().String()
+
+	fake := network.NewFake()
+	e := dispatcher.NewEngine()
+	e.Dialer = fake
+	e.Resolver = fake
+	fake.Handle(dst, network.Accept, func(c net.Conn) {
+		_, _ = c.Read(make([]byte, 1024))
+		_, _ = c.Write([]byte("ok"))
+	})
+	s := &Server{Addr: "127.0.0.1:1", Config: &server.Config{UpstreamTimeout: 200 * time.Millisecond}, Engine: e}
+	done := make(chan bool)
+	go func() {
+		done <- s.Serve(bufconn.NewConn(conn))
+		conn.Close()
+	}()
+	_, _ = client.Write([]byte("hello"))
+	b := make([]byte, 2)
+	_, _ = io.ReadFull(client, b)
+	client.Close()
+	if !<-done || string(b) != "ok" || fake.Dials(dst) != 1 {
+		t.Fatalf("serve: %q, %v", b, fake.Dials(dst))
+	}
+}
+
+func TestNotRedirected(t *testing.T) {
+	client, conn := accept(t)
+	defer client.Close()
+	defer conn.Close()
+
+	s := &Server{Addr: conn.LocalAddr().String()}
+	if _, err := s.OriginalDst(conn); err != ErrNotRedirected {
+		t.Fatalf("connected to the listener: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(s.Addr)
+	s.Addr = ":" + port
+	if _, err := s.OriginalDst(conn); err != ErrNotRedirected {
+		t.Fatalf("connected to the listener on all: %v", err)
+	}
+	// The same port of another address is redirected.
+	for _, addr := range []string{"127.0.0.1:1", "127.0.0.2:" + port} {
+		s.Addr = addr
+		if dst, err := s.OriginalDst(conn); err != nil || dst.String() != conn.LocalAddr().String() {
+			t.Fatalf("TPROXY by %v: %v, %v", addr, dst, err)
+		}
+	}
+}
@@ -6,6 +6,8 @@
 package tcp
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net"
 	"time"
@@ -15,8 +17,21 @@ import (
 	"github.com/lifenjoiner/pd/server/socket/http"
 	"github.com/lifenjoiner/pd/server/socket/socks/socks4a"
 	"github.com/lifenjoiner/pd/server/socket/socks/socks5"
+	"github.com/lifenjoiner/pd/server/socket/transparent"
 )
 
+// The back-off range of the accept errors.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// errNotAllowed is the error of a client denied by the ACL.
+var errNotAllowed = errors.New("not allowed")
+
+// refuseTimeout is the time a refused client has to send its request, so a flood doesn't hold the fds.
+const refuseTimeout = time.Second
+
 // Server stores the socks/http proxy config.
 type Server server.Server
 
@@ -28,22 +43,72 @@ func (s *Server) ListenAndServe() {
 	if s.Addr[0] >= '0' && s.Addr[0] <= '9' {
 		network += "4"
 	}
-	l, err := net.Listen(network, s.Addr)
+	lc := &net.ListenConfig{}
+	if s.Transparent {
+		lc.Control = transparent.Control
+	}
+	l, err := lc.Listen(context.Background(), network, s.Addr)
+	if err != nil && s.Transparent && transparent.Supported {
+		// Without CAP_NET_ADMIN, only REDIRECT works.
+		log.Printf("[tcp] no TPROXY on %s: %v\n", s.Addr, err)
+		l, err = net.Listen(network, s.Addr)
+	}
 	if err != nil {
 		log.Printf("[tcp] failed to listen on %s: %v\n", s.Addr, err)
 		return
 	}
 	defer l.Close()
 
-	log.Printf("[tcp] listening on %s\n", s.Addr)
+	if s.Transparent {
+		log.Printf("[tcp] listening on %s for transparent proxying\n", s.Addr)
+	} else {
+		log.Printf("[tcp] listening on %s\n", s.Addr)
+	}
+	var backoff time.Duration
 	for {
 		c, err := l.Accept()
 		if err != nil {
-			log.Printf("[tcp] failed to accept: %v\n", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Likely out of file descriptors, back off to let the connections end.
+			if backoff == 0 {
+				backoff = minAcceptBackoff
+			} else if backoff *= 2; backoff > maxAcceptBackoff {
+				backoff = maxAcceptBackoff
+			}
+			log.Printf("[tcp] failed to accept: %v, retry in %v\n", err, backoff)
+			time.Sleep(backoff)
 			continue
 		}
+		backoff = 0
 		cc := bufconn.NewConn(c)
-		go s.Serve(cc)
+		go s.ServeLimited(cc)
+	}
+}
+
+// ServeLimited serves 1 client allowed and within the limits, or refuses it.
+// A denied client is refused before the limits, so it doesn't take a slot of the allowed ones.
+func (s *Server) ServeLimited(c *bufconn.Conn) {
+	var err error
+	limiter := s.Config.Limiter
+	if !s.Config.ACL.Allowed(c.RemoteAddr()) {
+		err = errNotAllowed
+	} else if err = limiter.Acquire(c.RemoteAddr()); err == nil {
+		defer limiter.Release(c.RemoteAddr())
+		s.Serve(c)
+		return
+	}
+	defer c.Close()
+	_ = c.SetDeadline(time.Now().Add(refuseTimeout))
+	log.Printf("[tcp] refuse %v: %v", c.RemoteAddr(), err)
+	// There is no protocol to refuse in for a transparent one.
+	if s.Transparent {
+		return
+	}
+	data, err := c.R.Peek(1)
+	if err == nil {
+		s.refuse(c, data[0])
 	}
 }
 
@@ -52,6 +117,11 @@ func (s *Server) Serve(c *bufconn.Conn) {
 	defer c.Close()
 	_ = c.SetDeadline(time.Now().Add(2 * s.Config.UpstreamTimeout))
 
+	if s.Transparent {
+		transparent := (*transparent.Server)(s)
+		transparent.Serve(c)
+		return
+	}
 	data, err := c.R.Peek(1)
 	if err != nil {
 		log.Printf("[tcp] drop %v, error: %v", c.RemoteAddr(), err)
@@ -69,3 +139,18 @@ func (s *Server) Serve(c *bufconn.Conn) {
 		http.Serve(c)
 	}
 }
+
+// refuse the client in its protocol.
+func (s *Server) refuse(c *bufconn.Conn, ver byte) {
+	switch ver {
+	case 5:
+		socks5 := (*socks5.Server)(s)
+		socks5.Refuse(c)
+	case 4:
+		socks4a := (*socks4a.Server)(s)
+		socks4a.Refuse(c)
+	default:
+		http := (*http.Server)(s)
+		http.Refuse(c)
+	}
+}
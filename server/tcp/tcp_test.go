@@ -0,0 +1,55 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package tcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lifenjoiner/pd/bufconn"
+	"github.com/lifenjoiner/pd/server"
+)
+
+// addrConn is a piped connection from a client address.
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestServeLimitedDenied(t *testing.T) {
+	acl, err := server.ParseACL("", "192.168.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &server.Limiter{MaxConns: 1, MaxClientConns: 1}
+	s := &Server{Config: &server.Config{ACL: acl, Limiter: l, UpstreamTimeout: 200 * time.Millisecond}}
+
+	// The denied clients connect and say nothing.
+	denied := &net.TCPAddr{IP: net.ParseIP("192.168.2.3"), Port: 1}
+	done := make(chan bool)
+	var clients []net.Conn
+	for i := 0; i < 3; i++ {
+		client, conn := net.Pipe()
+		clients = append(clients, client)
+		go func() {
+			s.ServeLimited(bufconn.NewConn(addrConn{conn, denied}))
+			done <- true
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	allowed := &net.TCPAddr{IP: net.ParseIP("192.168.2.2"), Port: 1}
+	if l.Acquire(allowed) != nil {
+		t.Fatal("the denied clients shouldn't take the slots")
+	}
+	l.Release(allowed)
+	for _, c := range clients {
+		c.Close()
+		<-done
+	}
+}
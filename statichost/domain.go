@@ -0,0 +1,41 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package statichost
+
+import (
+	"strings"
+)
+
+// Generic second-level labels under ccTLDs, like `co.uk` and `com.cn`.
+var genericSLDs = map[string]bool{
+	"ac":  true,
+	"co":  true,
+	"com": true,
+	"edu": true,
+	"go":  true,
+	"gov": true,
+	"ne":  true,
+	"net": true,
+	"or":  true,
+	"org": true,
+}
+
+// RegistrableDomain guesses the registrable domain (eTLD+1) of a hostname. IPs are returned as is.
+// Without a public suffix list, a generic second-level label under a ccTLD is taken as a part of the suffix.
+func RegistrableDomain(host string) string {
+	if HostIsIP(host) {
+		return host
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	n := len(labels)
+	k := 2
+	if n > 2 && len(labels[n-1]) == 2 && genericSLDs[labels[n-2]] {
+		k = 3
+	}
+	if n <= k {
+		return host
+	}
+	return strings.Join(labels[n-k:], ".")
+}
@@ -0,0 +1,83 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package statichost
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+)
+
+/* LearnedHosts example:
+{
+	"example.com": "2021-08-25T21:46:05.9266165+08:00"
+}
+*/
+
+// LearnedHosts are the hosts promoted from stats, each one expires at its time.
+type LearnedHosts map[string]time.Time
+
+// Load LearnedHosts from a file, the expired ones are dropped.
+func (lh LearnedHosts) Load(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[statichost] %v", err)
+		}
+		return
+	}
+	hosts := make(map[string]time.Time)
+	err = json.Unmarshal(data, &hosts)
+	if err != nil {
+		log.Printf("[statichost] %v: %v", file, err)
+	}
+	now := time.Now()
+	for h, t := range hosts {
+		if t.After(now) {
+			lh[h] = t
+		}
+	}
+}
+
+// Save LearnedHosts to a file.
+func (lh LearnedHosts) Save(file string) {
+	data, err := json.MarshalIndent(lh, "", "\t")
+	if err != nil {
+		log.Printf("[statichost] %v", err)
+		return
+	}
+	err = os.WriteFile(file, data, 0666)
+	if err != nil {
+		log.Printf("[statichost] %v", err)
+	}
+}
+
+// Promote hosts for the duration from now on.
+func (lh LearnedHosts) Promote(hosts []string, d time.Duration) {
+	t := time.Now().Add(d)
+	for _, h := range hosts {
+		lh[h] = t
+	}
+}
+
+// Demote hosts.
+func (lh LearnedHosts) Demote(hosts []string) {
+	for _, h := range hosts {
+		delete(lh, h)
+	}
+}
+
+// Learn merges the unexpired LearnedHosts from a file for a strategy. The existing rules win.
+// Keep them apart from the static rules, as a learned domain shouldn't override a static subdomain.
+func (sh StaticHosts) Learn(file string, strategy Strategy) {
+	lh := LearnedHosts{}
+	lh.Load(file)
+	for h := range lh {
+		if sh[h] == StaticNil {
+			sh[h] = strategy
+		}
+	}
+}
@@ -67,3 +67,23 @@ api.github.com
 		t.Fail()
 	}
 }
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := map[string]string{
+		"www.google.com":   "google.com",
+		"google.com":       "google.com",
+		"com":              "com",
+		"www.bbc.co.uk":    "bbc.co.uk",
+		"bbc.co.uk":        "bbc.co.uk",
+		"a.b.example.com.": "example.com",
+		"news.sina.com.cn": "sina.com.cn",
+		"www.example.de":   "example.de",
+		"192.168.1.1":      "192.168.1.1",
+		"2001:db8::1":      "2001:db8::1",
+	}
+	for h, d := range tests {
+		if r := RegistrableDomain(h); r != d {
+			t.Errorf("%v: %v, expected = %v", h, r, d)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package statichost
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+/* TLSRules example, the first matched line wins:
+# ALPN h2 with SNI example.com goes direct
+sni=example.com alpn=h2 direct
+# ClientHello without SNI goes proxied
+nosni blocked
+*/
+
+// TLSRule matches the ClientHello of a TLS connection.
+// SNI: sufix like StaticHosts, or exact match with a leading `=`. Empty fields match all.
+type TLSRule struct {
+	SNI      string
+	ALPN     string
+	NoSNI    bool
+	Strategy Strategy
+}
+
+// TLSRules are the TLSRule list in order.
+type TLSRules []TLSRule
+
+// ParseTLSRule parses a line of TLSRule.
+func ParseTLSRule(line string) (r TLSRule, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return r, fmt.Errorf("bad TLS rule: %q", line)
+	}
+	switch fields[len(fields)-1] {
+	case "direct":
+		r.Strategy = StaticDirect
+	case "blocked":
+		r.Strategy = StaticBlocked
+	default:
+		return r, fmt.Errorf("bad TLS rule strategy: %q", line)
+	}
+	for _, f := range fields[:len(fields)-1] {
+		switch {
+		case f == "nosni":
+			r.NoSNI = true
+		case strings.HasPrefix(f, "sni="):
+			r.SNI = strings.ToLower(f[4:])
+		case strings.HasPrefix(f, "alpn="):
+			r.ALPN = f[5:]
+		default:
+			return r, fmt.Errorf("bad TLS rule condition: %q", line)
+		}
+	}
+	if r.NoSNI && r.SNI != "" {
+		return r, fmt.Errorf("conflicting TLS rule: %q", line)
+	}
+	return r, nil
+}
+
+// Load TLSRules from a file.
+func (tr *TLSRules) Load(file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Printf("[statichost] %v: %v", file, err)
+	}
+	tr.Upsert(string(data))
+}
+
+// Upsert appends the TLSRules by line(s), the bad ones are logged and skipped.
+func (tr *TLSRules) Upsert(in string) {
+	for _, line := range strings.Split(in, "\n") {
+		line = strings.TrimSpace(line)
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = strings.TrimSpace(line[:i])
+		}
+		if line == "" {
+			continue
+		}
+		r, err := ParseTLSRule(line)
+		if err != nil {
+			log.Printf("[statichost] %v", err)
+			continue
+		}
+		*tr = append(*tr, r)
+	}
+}
+
+// Match tells if the rule matches the SNI and ALPN list.
+func (r *TLSRule) Match(sni string, alpn []string) bool {
+	if r.NoSNI && sni != "" {
+		return false
+	}
+	if r.SNI != "" {
+		sni = strings.ToLower(sni)
+		if r.SNI[0] == '=' {
+			if sni != r.SNI[1:] {
+				return false
+			}
+		} else if sni != r.SNI && !strings.HasSuffix(sni, "."+r.SNI) {
+			return false
+		}
+	}
+	if r.ALPN != "" {
+		found := false
+		for _, p := range alpn {
+			if p == r.ALPN {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// GetStrategy gets the strategy of the first matched rule, or StaticNil.
+func (tr TLSRules) GetStrategy(sni string, alpn []string) Strategy {
+	for i := range tr {
+		if tr[i].Match(sni, alpn) {
+			return tr[i].Strategy
+		}
+	}
+	return StaticNil
+}
@@ -0,0 +1,42 @@
+// Copyright 2021-now by lifenjoiner. All rights reserved.
+// Use of this source code is governed by a MIT license
+// that can be found in the LICENSE file.
+
+package statichost
+
+import (
+	"testing"
+)
+
+func TestTLSRules(t *testing.T) {
+	var tr TLSRules
+	tr.Upsert(`# ALPN h2 with SNI example.com goes direct
+sni=example.com alpn=h2 direct
+sni==example.org blocked
+nosni blocked # without SNI
+sni=bad proxied
+`)
+	if len(tr) != 3 {
+		t.Fatalf("rules: %v", tr)
+	}
+	cases := []struct {
+		sni  string
+		alpn []string
+		want Strategy
+	}{
+		{"www.example.com", []string{"h2", "http/1.1"}, StaticDirect},
+		{"www.example.com", []string{"http/1.1"}, StaticNil},
+		{"example.org", nil, StaticBlocked},
+		{"www.example.org", nil, StaticNil},
+		{"", []string{"h2"}, StaticBlocked},
+		{"notexample.com", []string{"h2"}, StaticNil},
+	}
+	for _, c := range cases {
+		if s := tr.GetStrategy(c.sni, c.alpn); s != c.want {
+			t.Errorf("%q %v: %v, want %v", c.sni, c.alpn, s, c.want)
+		}
+	}
+	if _, err := ParseTLSRule("nosni sni=example.com direct"); err == nil {
+		t.Error("conflicting rule should fail")
+	}
+}